@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/3096/furnace/commands"
+	"github.com/3096/furnace/furnace/formats"
+)
+
+const (
+	EXIT_OK    = 0
+	EXIT_ERROR = 1
+	EXIT_USAGE = 2
+)
+
+const ARGS_COUNT_ANY = -1
+
+type command struct {
+	name        string
+	argsUsage   string
+	summary     string
+	description string
+	argsCount   int
+	// registers the flags of the command and returns the function running it with the positional args
+	setup func(flagSet *flag.FlagSet) func(args []string) error
+}
+
+var commandList []*command
+
+func registerCommand(cmd *command) {
+	commandList = append(commandList, cmd)
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commandList {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func Run(programPath string, args []string) int {
+	programName := filepath.Base(programPath)
+	if len(args) == 0 {
+		printUsage(os.Stderr, programName)
+		return EXIT_USAGE
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 {
+			cmd := findCommand(args[1])
+			if cmd == nil {
+				fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[1])
+				return EXIT_USAGE
+			}
+			flagSet, _ := cmd.newFlagSet(programName)
+			cmd.printUsage(os.Stdout, programName, flagSet)
+			return EXIT_OK
+		}
+		printUsage(os.Stdout, programName)
+		return EXIT_OK
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		if len(args) == 3 && isWismtFile(args[0]) {
+			// the only form before there were subcommands
+			fmt.Fprintf(os.Stderr, "Deprecated: use \"%s replace <in wismt> <texture dir> <out wismt>\" instead\n", programName)
+			return runLegacyReplace(args)
+		}
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
+		printUsage(os.Stderr, programName)
+		return EXIT_USAGE
+	}
+
+	return cmd.run(programName, args[1:])
+}
+
+// isWismtFile tells a wismt given to the old three-argument form from a mistyped command
+func isWismtFile(path string) bool {
+	if !strings.EqualFold(filepath.Ext(path), ".wismt") {
+		return false
+	}
+	fileInfo, err := os.Stat(path)
+	return err == nil && fileInfo.Mode().IsRegular()
+}
+
+func runLegacyReplace(args []string) int {
+	if err := commands.ReplaceTexturesInWismt(args[0], args[1], args[2]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_ERROR
+	}
+	return EXIT_OK
+}
+
+func printUsage(writer io.Writer, programName string) {
+	fmt.Fprintf(writer, "Usage: %s <command> [flags] [args]\n\nCommands:\n", programName)
+	for _, cmd := range commandList {
+		fmt.Fprintf(writer, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(writer, "\nRun \"%s help <command>\" for more information on a command.\n", programName)
+}
+
+func (cmd *command) newFlagSet(programName string) (*flag.FlagSet, func(args []string) error) {
+	flagSet := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flagSet.SetOutput(os.Stderr)
+	flagSet.Usage = func() {
+		cmd.printUsage(flagSet.Output(), programName, flagSet)
+	}
+	return flagSet, cmd.setup(flagSet)
+}
+
+func (cmd *command) printUsage(writer io.Writer, programName string, flagSet *flag.FlagSet) {
+	fmt.Fprintf(writer, "Usage: %s %s [flags] %s\n\n%s\n", programName, cmd.name, cmd.argsUsage, cmd.description)
+	hasFlags := false
+	flagSet.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintf(writer, "\nFlags:\n")
+		flagSet.SetOutput(writer)
+		flagSet.PrintDefaults()
+		flagSet.SetOutput(os.Stderr)
+	}
+}
+
+func (cmd *command) run(programName string, args []string) int {
+	flagSet, runCommand := cmd.newFlagSet(programName)
+	if err := flagSet.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return EXIT_OK
+		}
+		return EXIT_USAGE
+	}
+	if cmd.argsCount != ARGS_COUNT_ANY && flagSet.NArg() != cmd.argsCount {
+		fmt.Fprintf(os.Stderr, "Expected %d arguments, got %d\n\n", cmd.argsCount, flagSet.NArg())
+		flagSet.Usage()
+		return EXIT_USAGE
+	}
+
+	if err := runCommand(flagSet.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_ERROR
+	}
+	return EXIT_OK
+}
+
+// compressionLevelValue is a flag taking a level name (fast, default, best) or number
+type compressionLevelValue struct {
+	level *formats.XBC1CompressionLevel
+}
+
+func (value compressionLevelValue) String() string {
+	if value.level == nil {
+		return formats.XBC1_COMPRESSION_LEVEL_DEFAULT.String()
+	}
+	return value.level.String()
+}
+
+func (value compressionLevelValue) Set(name string) error {
+	level, err := commands.ParseXBC1CompressionLevel(name)
+	if err != nil {
+		return err
+	}
+	*value.level = level
+	return nil
+}
+
+func compressionLevelVar(flagSet *flag.FlagSet, level *formats.XBC1CompressionLevel) {
+	flagSet.Var(compressionLevelValue{level}, "level",
+		"compression `level`: fast, default (same as best), best or 1 to 9")
+}
@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"flag"
+
+	"github.com/3096/furnace/commands"
+)
+
+func init() {
+	registerCommand(&command{
+		name:      "extract",
+		argsUsage: "<in wismt> <texture dir>",
+		summary:   "extract the textures of a wismt to DDS files",
+		description: `Writes every texture of <in wismt> to <texture dir> as a DDS file named <id.name.dds>, ready to be
+edited and passed back to the replace command.`,
+		argsCount: 2,
+		setup: func(flagSet *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				return commands.ExtractTexturesFromWismt(args[0], args[1])
+			}
+		},
+	})
+}
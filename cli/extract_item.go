@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"flag"
+
+	"github.com/3096/furnace/commands"
+)
+
+func init() {
+	registerCommand(&command{
+		name:      "extract-item",
+		argsUsage: "<in wismt> <item> <out file>",
+		summary:   "extract the data of one data item of a wismt",
+		description: `Writes the data of one data item of <in wismt> to <out file>. <item> is either the index of the data
+item or its type name (model, shaderbundle, texturecache, texture) when only one item has that type.`,
+		argsCount: 3,
+		setup: func(flagSet *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				return commands.ExtractDataItemFromWismt(args[0], args[1], args[2])
+			}
+		},
+	})
+}
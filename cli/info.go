@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"flag"
+	"os"
+
+	"github.com/3096/furnace/commands"
+)
+
+func init() {
+	registerCommand(&command{
+		name:      "info",
+		argsUsage: "<in wismt>",
+		summary:   "print the structure of a wismt and its wimdo as JSON",
+		description: `Prints the headers, data items, file table, texture ids and texture infos parsed from <in wismt>,
+along with the header of its wimdo, as JSON to stdout.`,
+		argsCount: 1,
+		setup: func(flagSet *flag.FlagSet) func(args []string) error {
+			wimdoPath := flagSet.String("wimdo", "", "input wimdo `path` (default: next to <in wismt>, skipped if missing)")
+			return func(args []string) error {
+				return commands.PrintWismtInfo(os.Stdout, args[0], *wimdoPath)
+			}
+		},
+	})
+}
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"flag"
+
+	"github.com/3096/furnace/commands"
+)
+
+func init() {
+	registerCommand(&command{
+		name:      "pack",
+		argsUsage: "<in dir> <out wismt>",
+		summary:   "build a wismt from a directory written by unpack",
+		description: `Builds <out wismt> from the manifest and files in <in dir>. Files that changed since unpacking are
+compressed again, the others keep their original compressed data when its copy is still in
+<in dir>/xbc1 and are compressed again otherwise. Table offsets and the file table
+are laid out again, so the wimdo has to be updated separately if the metadata changed.`,
+		argsCount: 2,
+		setup: func(flagSet *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				return commands.PackWismt(args[0], args[1])
+			}
+		},
+	})
+}
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"flag"
+
+	"github.com/3096/furnace/commands"
+)
+
+func init() {
+	registerCommand(&command{
+		name:      "replace",
+		argsUsage: "<in wismt> <texture dir> <out wismt>",
+		summary:   "replace textures in a wismt with DDS files",
+		description: `Replaces the textures of <in wismt> with the DDS files under <texture dir> and saves the result to
+<out wismt>, along with the updated wimdo.
+
+Texture files must be named <name.dds> or <id.name.dds>. The texture name is matched first, the id is only
+used when the name is unknown or shared by several textures. With -add-new, files named <name.dds> with an unknown
+name are added as new textures instead.
+Raw files placed in <texture dir>/raw named <index.whatever> replace the wismt file at that index.`,
+		argsCount: 3,
+		setup: func(flagSet *flag.FlagSet) func(args []string) error {
+			options := commands.ReplaceTexturesOptions{}
+			flagSet.StringVar(&options.InWimdoPath, "wimdo", "", "input wimdo `path` (default: next to <in wismt>)")
+			flagSet.StringVar(&options.OutWimdoPath, "out-wimdo", "", "output wimdo `path` (default: next to <out wismt>)")
+			flagSet.BoolVar(&options.DryRun, "dry-run", false, "process all replacements without saving anything")
+			flagSet.BoolVar(&options.AddNewTextures, "add-new", false, "add files named <name.dds> with a name not in the wismt as new textures")
+			compressionLevelVar(flagSet, &options.CompressionLevel)
+			flagSet.BoolVar(&options.CompareCompressionLevels, "compare-levels", false, "report the time and size of compressing the changed files at each level")
+			return func(args []string) error {
+				return commands.ReplaceTexturesInWismtWithOptions(args[0], args[1], args[2], options)
+			}
+		},
+	})
+}
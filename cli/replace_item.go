@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"flag"
+
+	"github.com/3096/furnace/commands"
+)
+
+func init() {
+	registerCommand(&command{
+		name:      "replace-item",
+		argsUsage: "<in wismt> <item> <in file> <out wismt>",
+		summary:   "replace the data of one data item of a wismt",
+		description: `Replaces the data of one data item of <in wismt> with <in file> and saves the result to <out wismt>,
+along with the updated wimdo. <item> is either the index of the data item or its type name (model,
+shaderbundle, texturecache, texture) when only one item has that type. The other items sharing its
+file are kept, the ones after it move and get their offsets updated.`,
+		argsCount: 4,
+		setup: func(flagSet *flag.FlagSet) func(args []string) error {
+			options := commands.ReplaceDataItemOptions{}
+			flagSet.StringVar(&options.InWimdoPath, "wimdo", "", "input wimdo `path` (default: next to <in wismt>)")
+			flagSet.StringVar(&options.OutWimdoPath, "out-wimdo", "", "output wimdo `path` (default: next to <out wismt>)")
+			compressionLevelVar(flagSet, &options.CompressionLevel)
+			return func(args []string) error {
+				return commands.ReplaceDataItemInWismt(args[0], args[1], args[2], args[3], options)
+			}
+		},
+	})
+}
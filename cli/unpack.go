@@ -0,0 +1,26 @@
+package cli
+
+import (
+	"flag"
+
+	"github.com/3096/furnace/commands"
+)
+
+func init() {
+	registerCommand(&command{
+		name:      "unpack",
+		argsUsage: "<in wismt> <out dir>",
+		summary:   "decompress every file of a wismt to a directory",
+		description: `Decompresses every file of <in wismt> to <out dir>/files and writes <out dir>/manifest.json, which
+records the headers, data items, texture infos and xbc1 names needed to pack the directory again.
+The original compressed files are kept in <out dir>/xbc1 so unchanged files pack back byte for byte.
+That directory is only a cache: it can be deleted to save space, unchanged files are then compressed
+again from their content, which gives the same content but not the same bytes.`,
+		argsCount: 2,
+		setup: func(flagSet *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				return commands.UnpackWismt(args[0], args[1])
+			}
+		},
+	})
+}
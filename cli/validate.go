@@ -0,0 +1,26 @@
+package cli
+
+import (
+	"flag"
+	"os"
+
+	"github.com/3096/furnace/commands"
+)
+
+func init() {
+	registerCommand(&command{
+		name:      "validate",
+		argsUsage: "<in wismt>",
+		summary:   "check a wismt and its wimdo against each other",
+		description: `Checks the file table of <in wismt> against its xbc1 files, the data items against the files
+holding them, the MIBLs of the textures and the alignments, and that the metadata copy in the wimdo
+matches. Every problem found is printed, and the exit code is 1 when there is any.`,
+		argsCount: 1,
+		setup: func(flagSet *flag.FlagSet) func(args []string) error {
+			wimdoPath := flagSet.String("wimdo", "", "input wimdo `path` (default: next to <in wismt>)")
+			return func(args []string) error {
+				return commands.PrintWismtValidation(os.Stdout, args[0], *wimdoPath)
+			}
+		},
+	})
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/3096/furnace/commands"
+)
+
+func init() {
+	registerCommand(&command{
+		name:      "xbc1",
+		argsUsage: "<decompress|compress> <in file> <out file>",
+		summary:   "decompress or compress a single xbc1 file",
+		description: `Decompresses an xbc1 file from any game format to its raw content, or compresses a raw file into an
+xbc1. Use - as <in file> or <out file> to read from stdin or write to stdout.`,
+		argsCount: 3,
+		setup: func(flagSet *flag.FlagSet) func(args []string) error {
+			options := commands.XBC1Options{}
+			flagSet.StringVar(&options.Name, "name", "", "`name` stored in the xbc1 when compressing (default: <in file> without extension)")
+			flagSet.StringVar(&options.NameFromPath, "name-from", "", "keep the name of this xbc1 `file` when compressing")
+			compressionTypeName := flagSet.String("type", "", "compression `type` when compressing: zlib or zstd (default: the type of -name-from, or zlib)")
+			compressionLevelVar(flagSet, &options.CompressionLevel)
+			return func(args []string) error {
+				switch args[0] {
+				case "decompress", "d":
+					return commands.DecompressXBC1(args[1], args[2])
+				case "compress", "c":
+					if *compressionTypeName != "" {
+						compressionType, err := commands.ParseXBC1CompressionType(*compressionTypeName)
+						if err != nil {
+							return err
+						}
+						options.CompressionType = compressionType
+					}
+					return commands.CompressXBC1(args[1], args[2], options)
+				}
+				return errors.New("Unknown xbc1 mode " + args[0] + ", expected decompress or compress")
+			}
+		},
+	})
+}
@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/3096/furnace/furnace/formats"
+)
+
+type ReplaceDataItemOptions struct {
+	// defaults to the wimdo next to the input wismt
+	InWimdoPath string
+	// defaults to the wimdo next to the output wismt
+	OutWimdoPath     string
+	CompressionLevel formats.XBC1CompressionLevel
+}
+
+// ResolveDataItem finds the data item given either by its index or by its type name, like "shaderbundle". The model,
+// shader bundle and texture cache are the items the metadata header gives for them, other type names have to match
+// exactly one item.
+func ResolveDataItem(wismt *formats.MSRD, item string) (int, error) {
+	if index, err := strconv.Atoi(item); err == nil {
+		if index < 0 || index >= len(wismt.DataItems) {
+			return 0, errors.New("data item index " + item + " is out of range, the wismt has " +
+				fmt.Sprint(len(wismt.DataItems)) + " data items")
+		}
+		return index, nil
+	}
+	for _, dataItemType := range []formats.MSRDDataItemType{formats.MSRD_DATA_ITEM_TYPE_MODEL,
+		formats.MSRD_DATA_ITEM_TYPE_SHADERBUNDLE, formats.MSRD_DATA_ITEM_TYPE_TEXTURECACHE} {
+		if dataItemType.String() == item {
+			return wismt.GetHeaderDataItemIndex(dataItemType)
+		}
+	}
+
+	var indices []int
+	for i, dataItem := range wismt.DataItems {
+		if dataItem.Type.String() == item {
+			indices = append(indices, i)
+		}
+	}
+	if len(indices) == 0 {
+		return 0, errors.New("no data item of type " + item)
+	}
+	if len(indices) > 1 {
+		return 0, errors.New("data item type " + item + " is shared by items " + fmt.Sprint(indices) + ", please use an index")
+	}
+	return indices[0], nil
+}
+
+func ExtractDataItemFromWismt(inWismtPath, item, outDataPath string) error {
+	fmt.Printf("Reading wismt file: %s...\n", inWismtPath)
+	inWismtFile, err := os.Open(inWismtPath)
+	defer inWismtFile.Close()
+	if err != nil {
+		return err
+	}
+	wismt, err := formats.ReadMSRD(inWismtFile)
+	if err != nil {
+		return err
+	}
+
+	index, err := ResolveDataItem(&wismt, item)
+	if err != nil {
+		return err
+	}
+	data, err := wismt.GetDataItemData(index)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(outDataPath, data, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Done: extracted data item %d (%s), output: %s\n", index, wismt.DataItems[index].Type, outDataPath)
+	return nil
+}
+
+// ReplaceDataItemInWismt swaps the data of one item, leaving the other items of its file as they are, and saves
+// the wismt along with the wimdo holding the updated metadata
+func ReplaceDataItemInWismt(inWismtPath, item, inDataPath, outWismtPath string, options ReplaceDataItemOptions) error {
+	fmt.Printf("Reading wismt file: %s...\n", inWismtPath)
+	inWismtFile, err := os.Open(inWismtPath)
+	defer inWismtFile.Close()
+	if err != nil {
+		return err
+	}
+	wismt, err := formats.ReadMSRD(inWismtFile)
+	if err != nil {
+		return err
+	}
+	origMetaData := wismt.MetaData
+	wismt.CompressionLevel = options.CompressionLevel
+
+	index, err := ResolveDataItem(&wismt, item)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(inDataPath)
+	if err != nil {
+		return err
+	}
+	wimdo, err := ReadWimdo(options.InWimdoPath, inWismtPath, wismt.MetaData)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Replacing data item %d (%s) in file%d...\n", index, wismt.DataItems[index].Type, wismt.GetDataItemFileIndex(index))
+	if err := wismt.SetDataItemData(index, data); err != nil {
+		return err
+	}
+	if err := wismt.UpdateMetaData(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saving wismt file: %s...\n", outWismtPath)
+	outWismtFile, err := os.Create(outWismtPath)
+	defer outWismtFile.Close()
+	if err != nil {
+		return err
+	}
+	if err := formats.WriteMSRD(outWismtFile, wismt); err != nil {
+		return err
+	}
+	if err := SaveWimdo(wimdo, origMetaData, wismt.MetaData, options.OutWimdoPath, outWismtPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Done: replaced data item %d, output: %s\n", index, outWismtPath)
+	return nil
+}
@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/3096/furnace/dds"
+	"github.com/3096/furnace/furnace"
+	"github.com/3096/furnace/furnace/formats"
+)
+
+func ExtractTexturesFromWismt(inWismtPath, outTextureDir string) error {
+	fmt.Printf("Reading wismt file: %s...\n", inWismtPath)
+	inWismtFile, err := os.Open(inWismtPath)
+	defer inWismtFile.Close()
+	if err != nil {
+		return err
+	}
+	wismt, err := formats.ReadMSRD(inWismtFile)
+	if err != nil {
+		return err
+	}
+	wismtCachedTextures, err := wismt.GetCachedTextures()
+	if err != nil {
+		return err
+	}
+	mipsMIBLs, err := wismt.GetSplitMips()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(outTextureDir, 0755); err != nil {
+		return err
+	}
+
+	wismtName := strings.TrimSuffix(filepath.Base(inWismtPath), filepath.Ext(inWismtPath))
+	totalTexturesExtracted := 0
+	for textureId := range wismtCachedTextures {
+		textureName := wismt.TextureNames[textureId]
+		if textureName == "" {
+			textureName = wismtName
+		}
+		outTexturePath := filepath.Join(outTextureDir, fmt.Sprintf("%02d%c%s.dds", textureId, INDEX_SEPARATOR, textureName))
+
+		width, height, format, mips, err := GetTextureMips(&wismt, formats.MSRDTextureId(textureId), wismtCachedTextures, mipsMIBLs)
+		if err != nil {
+			fmt.Printf("Skipped due to error - %s: %s\n", err, outTexturePath)
+			continue
+		}
+
+		outTextureFile, err := os.Create(outTexturePath)
+		if err != nil {
+			fmt.Printf("Skipped due to error - %s: %s\n", err, outTexturePath)
+			continue
+		}
+		err = dds.WriteDDS(outTextureFile, dds.DDSHeader{Width: width, Height: height},
+			dds.DDSHeaderDXT10{DxgiFormat: format}, [][][]byte{mips})
+		outTextureFile.Close()
+		if err != nil {
+			fmt.Printf("Skipped due to error - %s: %s\n", err, outTexturePath)
+			continue
+		}
+
+		totalTexturesExtracted++
+		fmt.Printf("Successfully extracted %s\n", outTexturePath)
+	}
+
+	if totalTexturesExtracted == 0 {
+		return errors.New("No textures extracted")
+	}
+
+	fmt.Printf("Done: extracted %d textures, output: %s\n", totalTexturesExtracted, outTextureDir)
+	return nil
+}
+
+// GetTextureMips rebuilds the full mip chain of a texture: the high-res stream file provides mip 0 and the split
+// mips the rest. Textures without a stream entry only exist in the low-res cache.
+func GetTextureMips(wismt *formats.MSRD, textureId formats.MSRDTextureId, cachedTextures []formats.MIBL,
+	splitMips []formats.MIBL) (uint32, uint32, dds.DXGIFormat, [][]byte, error) {
+
+	if int(textureId) >= len(cachedTextures) {
+		return 0, 0, 0, nil, errors.New("texture id out of range: " + fmt.Sprint(textureId))
+	}
+
+	textureIndex, hasFileEntry := wismt.TextureIdToIndexMap[textureId]
+	if !hasFileEntry {
+		cachedTexture := cachedTextures[textureId]
+		cacheFooter, err := cachedTexture.GetFooter()
+		if err != nil {
+			return 0, 0, 0, nil, err
+		}
+		mips, err := cachedTexture.GetMips()
+		if err != nil {
+			return 0, 0, 0, nil, err
+		}
+		return cacheFooter.Width, cacheFooter.Height, formats.MIBLFormatToDXGIFormat[cacheFooter.Format], mips, nil
+	}
+
+	mipsMIBL := splitMips[textureIndex]
+	mipsFooter, err := mipsMIBL.GetFooter()
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+	format, found := formats.MIBLFormatToDXGIFormat[mipsFooter.Format]
+	if !found {
+		return 0, 0, 0, nil, errors.New("Unsupported MIBL format: " + fmt.Sprint(mipsFooter.Format))
+	}
+	mips, err := mipsMIBL.GetMips()
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+
+	_, highResData, err := formats.ExtractXBC1(bytes.NewReader(wismt.CompressedFiles[formats.MSRD_FILE_INDEX_TEXTURE_START+textureIndex]))
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+	width := mipsFooter.Width * 2
+	height := mipsFooter.Height * 2
+	highResMip, err := furnace.GetDeswizzled(highResData, width, height, format)
+	if err != nil {
+		return 0, 0, 0, nil, errors.New("Error reading high-res texture: " + err.Error())
+	}
+
+	return width, height, format, append([][]byte{highResMip}, mips...), nil
+}
@@ -8,8 +8,10 @@ import (
 	"math/bits"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/3096/furnace/dds"
 	"github.com/3096/furnace/furnace"
@@ -20,7 +22,35 @@ const INDEX_SEPARATOR = rune('.')
 const RAW_REPLACE_DIR = "raw"
 const FILE_INDEX_NO_ENTRY = -1
 
+// new textures get the largest mip that fits in this size as their low-res cache
+const NEW_TEXTURE_CACHE_SIZE = 32
+
+type ReplaceTexturesOptions struct {
+	// defaults to the wimdo next to the input wismt
+	InWimdoPath string
+	// defaults to the wimdo next to the output wismt
+	OutWimdoPath string
+	// process all replacements without saving anything
+	DryRun bool
+	// add files named <name.dds> with a name that isn't in the wismt as new textures
+	AddNewTextures bool
+	// level of the compressed files, fast for iterating and best for release
+	CompressionLevel formats.XBC1CompressionLevel
+	// report the time and size it takes to compress the changed files at every level
+	CompareCompressionLevels bool
+}
+
+// levels reported by CompareCompressionLevels
+var COMPARED_COMPRESSION_LEVELS = []formats.XBC1CompressionLevel{
+	formats.XBC1_COMPRESSION_LEVEL_FAST, 6, formats.XBC1_COMPRESSION_LEVEL_BEST,
+}
+
 func ReplaceTexturesInWismt(inWismtPath, inTextureDir, outWismtPath string) error {
+	return ReplaceTexturesInWismtWithOptions(inWismtPath, inTextureDir, outWismtPath, ReplaceTexturesOptions{})
+}
+
+func ReplaceTexturesInWismtWithOptions(inWismtPath, inTextureDir, outWismtPath string, options ReplaceTexturesOptions) error {
+	startTime := time.Now()
 	fmt.Printf("Reading wismt file: %s...\n", inWismtPath)
 	inWismtFile, err := os.Open(inWismtPath)
 	defer inWismtFile.Close()
@@ -31,6 +61,9 @@ func ReplaceTexturesInWismt(inWismtPath, inTextureDir, outWismtPath string) erro
 	if err != nil {
 		return err
 	}
+	origMetaData := wismt.MetaData
+	origCompressedFiles := append([]formats.XBC1{}, wismt.CompressedFiles...)
+	wismt.CompressionLevel = options.CompressionLevel
 	wismtCachedTextures, err := wismt.GetCachedTextures()
 	if err != nil {
 		return err
@@ -49,24 +82,40 @@ func ReplaceTexturesInWismt(inWismtPath, inTextureDir, outWismtPath string) erro
 	fileReadChan := make(chan *FileReadResult, len(inTextureDirFileInfos)+len(inRawReplaceDirFileInfos))
 	routinesRunning := 0
 
+	resolvedTextureFileNames := make(map[formats.MSRDTextureId]string)
+	nextNewTextureFileIndex := len(wismt.CompressedFiles)
 	for _, inTextureFileInfo := range inTextureDirFileInfos {
 		if inTextureFileInfo.IsDir() {
 			continue
 		}
 
-		inTextureId, err := strconv.Atoi(inTextureFileInfo.Name()[:strings.IndexRune(inTextureFileInfo.Name(), INDEX_SEPARATOR)])
+		if options.AddNewTextures {
+			_, hasTextureId, inTextureName := ParseTextureFileName(inTextureFileInfo.Name())
+			if !hasTextureId && inTextureName != "" && len(wismt.GetTextureIdsByName(inTextureName)) == 0 {
+				inTexturePath := filepath.Join(inTextureDir, inTextureFileInfo.Name())
+				go ReadNewTexture(inTexturePath, nextNewTextureFileIndex, inTextureName, wismt.GetCompressionType(),
+					options.CompressionLevel, fileReadChan)
+				nextNewTextureFileIndex++
+				routinesRunning++
+				continue
+			}
+		}
+
+		inTextureId, err := ResolveTextureFileName(&wismt, inTextureFileInfo.Name())
 		if err != nil {
-			fmt.Printf("Skipping %s: no id number found in filename, please use <id.name.dds> naming format\n", inTextureFileInfo.Name())
+			fmt.Printf("Skipping %s: %s\n", inTextureFileInfo.Name(), err)
 			continue
 		}
-		if inTextureId >= int(wismt.TextureInfoHeader.TextureCount) {
-			fmt.Printf("Skipping %s: id number is out of range\n", inTextureFileInfo.Name())
+		if otherFileName, found := resolvedTextureFileNames[inTextureId]; found {
+			fmt.Printf("Skipping %s: texture %d (%s) is already replaced by %s\n",
+				inTextureFileInfo.Name(), inTextureId, wismt.TextureNames[inTextureId], otherFileName)
 			continue
 		}
+		resolvedTextureFileNames[inTextureId] = inTextureFileInfo.Name()
 
 		origCachedTexture := wismtCachedTextures[inTextureId]
 		inTexturePath := filepath.Join(inTextureDir, inTextureFileInfo.Name())
-		inTextureIndex, hasFileEntry := wismt.TextureIdToIndexMap[formats.MSRDTextureId(inTextureId)]
+		inTextureIndex, hasFileEntry := wismt.TextureIdToIndexMap[inTextureId]
 		if hasFileEntry {
 			msrdFileIndex := formats.MSRD_FILE_INDEX_TEXTURE_START + inTextureIndex
 			xbc1Header, err := formats.ReadXBC1Header(bytes.NewReader(wismt.CompressedFiles[msrdFileIndex]))
@@ -75,10 +124,12 @@ func ReplaceTexturesInWismt(inWismtPath, inTextureDir, outWismtPath string) erro
 				continue
 			}
 
-			go ReadTexture(inTexturePath, msrdFileIndex, formats.MSRDTextureId(inTextureId), origCachedTexture, xbc1Header.Name, fileReadChan)
+			go ReadTexture(inTexturePath, msrdFileIndex, inTextureId, origCachedTexture, xbc1Header.Name, xbc1Header.CompressionType,
+				options.CompressionLevel, fileReadChan)
 
 		} else {
-			go ReadTexture(inTexturePath, FILE_INDEX_NO_ENTRY, formats.MSRDTextureId(inTextureId), origCachedTexture, [0x1C]byte{}, fileReadChan)
+			go ReadTexture(inTexturePath, FILE_INDEX_NO_ENTRY, inTextureId, origCachedTexture, [0x1C]byte{}, wismt.GetCompressionType(),
+				options.CompressionLevel, fileReadChan)
 		}
 
 		routinesRunning++
@@ -106,26 +157,13 @@ func ReplaceTexturesInWismt(inWismtPath, inTextureDir, outWismtPath string) erro
 		}
 
 		inRawReplacePath := filepath.Join(rawReplaceDir, inRawReplaceFileInfo.Name())
-		go ReadRaw(inRawReplacePath, inRawReplaceIndex, xbc1Header.Name, fileReadChan)
+		go ReadRaw(inRawReplacePath, inRawReplaceIndex, xbc1Header.Name, xbc1Header.CompressionType, options.CompressionLevel, fileReadChan)
 		routinesRunning++
 	}
 
-	fmt.Printf("Reading wimdo file: %s...\n", outWismtPath)
-	inWimdoFile, err := os.Open(strings.TrimSuffix(inWismtPath, filepath.Ext(inWismtPath)) + ".wimdo")
-	defer inWimdoFile.Close()
+	wimdo, err := ReadWimdo(options.InWimdoPath, inWismtPath, wismt.MetaData)
 	if err != nil {
-		return errors.New(err.Error() + "\nMake sure you place it in the same directory as the wismt file")
-	}
-	wimdo, err := formats.ReadMXMD(inWimdoFile)
-	if err != nil {
-		return errors.New("Could not read wimdo file: " + err.Error())
-	}
-	wimdoHeader, err := wimdo.GetHeader()
-	if err != nil {
-		return errors.New("Could not read wimdo header: " + err.Error())
-	}
-	if wimdoHeader.UncachedTexturesOffset == 0 {
-		return errors.New("Could not find uncached textures offset in wimdo file")
+		return err
 	}
 
 	fmt.Printf("Handling file reads...\n")
@@ -134,6 +172,8 @@ func ReplaceTexturesInWismt(inWismtPath, inTextureDir, outWismtPath string) erro
 		return err
 	}
 	totalFilesReplaced := 0
+	var promotedTextureResults []*FileReadResult
+	var newTextureResults []*FileReadResult
 	for routinesRunning > 0 {
 		result := <-fileReadChan
 		routinesRunning--
@@ -141,6 +181,14 @@ func ReplaceTexturesInWismt(inWismtPath, inTextureDir, outWismtPath string) erro
 			fmt.Printf("Skipped due to error - %s: %s\n", result.Err, result.Path)
 			continue
 		}
+		if result.TextureReadResult.NewTextureName != "" {
+			newTextureResults = append(newTextureResults, result)
+			continue
+		}
+		if result.FileIndex == FILE_INDEX_NO_ENTRY && result.CompressedData != nil {
+			promotedTextureResults = append(promotedTextureResults, result)
+			continue
+		}
 
 		if result.CompressedData != nil {
 			wismt.SetCompressedFileData(result.FileIndex, result.CompressedData)
@@ -152,25 +200,92 @@ func ReplaceTexturesInWismt(inWismtPath, inTextureDir, outWismtPath string) erro
 			wismtCachedTextures[result.TextureReadResult.TextureId] = result.TextureReadResult.CacheMIBL
 		}
 		totalFilesReplaced++
-		fmt.Printf("Successfully placed %s\n", result.Path)
+		if result.TextureReadResult.CacheMIBL != nil {
+			fmt.Printf("Successfully placed %s as %s\n", result.Path, wismt.TextureNames[result.TextureReadResult.TextureId])
+		} else {
+			fmt.Printf("Successfully placed %s\n", result.Path)
+		}
+	}
+
+	// promoted textures get their files in the order of their ids
+	sort.Slice(promotedTextureResults, func(i, j int) bool {
+		return promotedTextureResults[i].TextureReadResult.TextureId < promotedTextureResults[j].TextureReadResult.TextureId
+	})
+	for _, result := range promotedTextureResults {
+		textureId := result.TextureReadResult.TextureId
+		if err := wismt.PromoteTextureEntry(textureId, result.CompressedData); err != nil {
+			fmt.Printf("Skipped due to error - %s: %s\n", err, result.Path)
+			continue
+		}
+		wismtCachedTextures[textureId] = result.TextureReadResult.CacheMIBL
+		mipsMIBLs = append(mipsMIBLs, result.TextureReadResult.MipsMIBL)
+		totalFilesReplaced++
+		fmt.Printf("Successfully placed %s as %s, now streamed from file%d\n", result.Path, wismt.TextureNames[textureId],
+			formats.MSRD_FILE_INDEX_TEXTURE_START+wismt.TextureIdToIndexMap[textureId])
+	}
+
+	// new textures are added in the order they were dispatched so files keep the names given to them
+	sort.Slice(newTextureResults, func(i, j int) bool {
+		return newTextureResults[i].FileIndex < newTextureResults[j].FileIndex
+	})
+	for _, result := range newTextureResults {
+		newTextureName := result.TextureReadResult.NewTextureName
+		textureId, err := wismt.AddTextureEntry(newTextureName, wismt.GuessTextureUsage(newTextureName), result.CompressedData)
+		if err != nil {
+			fmt.Printf("Skipped due to error - %s: %s\n", err, result.Path)
+			continue
+		}
+		wismtCachedTextures = append(wismtCachedTextures, result.TextureReadResult.CacheMIBL)
+		mipsMIBLs = append(mipsMIBLs, result.TextureReadResult.MipsMIBL)
+		totalFilesReplaced++
+		fmt.Printf("Successfully added %s as new texture %d (%s, %s)\n", result.Path, textureId, newTextureName,
+			wismt.TextureInfoItems[textureId].Usage)
 	}
 
 	if totalFilesReplaced == 0 {
 		return errors.New("No files replaced")
 	}
 
-	fmt.Printf("Saving cached textures to file%d...\n", formats.MSRD_FILE_INDEX_0)
+	fmt.Printf("Saving cached textures to file%d...\n", wismt.MetaHeader.TextureCacheFileIndex)
 	err = wismt.SetCachedTextures(wismtCachedTextures)
 	if err != nil {
 		return errors.New("Could not save cached textures: " + err.Error())
 	}
 
-	fmt.Printf("Saving mipmaps to file%d...\n", formats.MSRD_FILE_INDEX_MIPS)
+	fmt.Printf("Saving mipmaps to file%d...\n", wismt.MetaHeader.MipsFileIndex)
 	err = wismt.SetMips(mipsMIBLs)
 	if err != nil {
 		return err
 	}
 
+	err = wismt.UpdateMetaData()
+	if err != nil {
+		return err
+	}
+
+	wismtSize := wismt.Header.MetaDataOffset + wismt.Header.MetaDataSize
+	for _, compressedFile := range wismt.CompressedFiles {
+		wismtSize += uint32(len(compressedFile))
+	}
+	changedFileIndices := getChangedFileIndices(&wismt, origCompressedFiles)
+	changedFilesSize := 0
+	for _, i := range changedFileIndices {
+		changedFilesSize += len(wismt.CompressedFiles[i])
+	}
+	fmt.Printf("Compression level %s: processed in %s, %d changed files take %d bytes, wismt size: %d bytes\n",
+		options.CompressionLevel, time.Since(startTime).Round(time.Millisecond), len(changedFileIndices), changedFilesSize,
+		wismtSize)
+	if options.CompareCompressionLevels {
+		if err := CompareCompressionLevels(&wismt, origCompressedFiles); err != nil {
+			return err
+		}
+	}
+
+	if options.DryRun {
+		fmt.Printf("Dry run done: would replace %d files, nothing saved\n", totalFilesReplaced)
+		return nil
+	}
+
 	fmt.Printf("Saving wismt file: %s...\n", outWismtPath)
 	outWismtFile, err := os.Create(outWismtPath)
 	defer outWismtFile.Close()
@@ -182,15 +297,7 @@ func ReplaceTexturesInWismt(inWismtPath, inTextureDir, outWismtPath string) erro
 		return err
 	}
 
-	outWimdoPath := strings.TrimSuffix(outWismtPath, filepath.Ext(outWismtPath)) + ".wimdo"
-	fmt.Printf("Saving wimdo file: %s...\n", outWimdoPath)
-	copy(wimdo[wimdoHeader.UncachedTexturesOffset:], formats.MXMD(wismt.MetaData))
-	outWimdoFile, err := os.Create(outWimdoPath)
-	defer outWimdoFile.Close()
-	if err != nil {
-		return err
-	}
-	err = formats.WriteMXMD(outWimdoFile, wimdo)
+	err = SaveWimdo(wimdo, origMetaData, wismt.MetaData, options.OutWimdoPath, outWismtPath)
 	if err != nil {
 		return err
 	}
@@ -199,10 +306,104 @@ func ReplaceTexturesInWismt(inWismtPath, inTextureDir, outWismtPath string) erro
 	return nil
 }
 
+// CompareCompressionLevels compresses the files that differ from the original ones again at every level, and
+// reports the time it took and the size of the compressed files
+func CompareCompressionLevels(wismt *formats.MSRD, origCompressedFiles []formats.XBC1) error {
+	changedFileIndices := getChangedFileIndices(wismt, origCompressedFiles)
+	var changedFileContents [][]byte
+	var changedFileHeaders []formats.XBC1Header
+	for _, i := range changedFileIndices {
+		header, content, err := formats.ExtractXBC1(bytes.NewReader(wismt.CompressedFiles[i]))
+		if err != nil {
+			return errors.New("Error extracting file " + fmt.Sprint(i) + ": " + err.Error())
+		}
+		changedFileContents = append(changedFileContents, content)
+		changedFileHeaders = append(changedFileHeaders, header)
+	}
+
+	fmt.Printf("Comparing compression levels on changed files %v...\n", changedFileIndices)
+	for _, level := range COMPARED_COMPRESSION_LEVELS {
+		levelStartTime := time.Now()
+		compressedSize := 0
+		for i, content := range changedFileContents {
+			compressedFile, err := formats.CompressToXBC1WithLevel(changedFileHeaders[i].CompressionType, level, changedFileHeaders[i].Name, content)
+			if err != nil {
+				return err
+			}
+			compressedSize += len(compressedFile)
+		}
+		fmt.Printf("  level %-7s %10s %12d bytes\n", level, time.Since(levelStartTime).Round(time.Millisecond), compressedSize)
+	}
+	return nil
+}
+
+// getChangedFileIndices lists the files that were added or differ from the original ones
+func getChangedFileIndices(wismt *formats.MSRD, origCompressedFiles []formats.XBC1) []int {
+	var changedFileIndices []int
+	for i, compressedFile := range wismt.CompressedFiles {
+		if i < len(origCompressedFiles) && bytes.Equal(compressedFile, origCompressedFiles[i]) {
+			continue
+		}
+		changedFileIndices = append(changedFileIndices, i)
+	}
+	return changedFileIndices
+}
+
+// ParseTextureFileName splits <id.name.dds>, <name.dds> or <id.dds> into the id and the texture name
+func ParseTextureFileName(fileName string) (int, bool, string) {
+	baseName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	if separatorIndex := strings.IndexRune(baseName, INDEX_SEPARATOR); separatorIndex >= 0 {
+		if id, err := strconv.Atoi(baseName[:separatorIndex]); err == nil {
+			return id, true, baseName[separatorIndex+1:]
+		}
+	} else if id, err := strconv.Atoi(baseName); err == nil {
+		return id, true, ""
+	}
+	return -1, false, baseName
+}
+
+// ResolveTextureFileName finds the texture a file replaces. The texture name in <id.name.dds> or <name.dds> is
+// looked up first, the id is only used when the name is unknown or to pick between textures sharing the name.
+func ResolveTextureFileName(wismt *formats.MSRD, fileName string) (formats.MSRDTextureId, error) {
+	textureId, hasTextureId, textureName := ParseTextureFileName(fileName)
+	if hasTextureId && (textureId < 0 || textureId >= len(wismt.TextureInfoItems)) {
+		return 0, errors.New("id number " + fmt.Sprint(textureId) + " is out of range, the wismt has " +
+			fmt.Sprint(len(wismt.TextureInfoItems)) + " textures")
+	}
+
+	if textureName != "" {
+		textureIds := wismt.GetTextureIdsByName(textureName)
+		if len(textureIds) == 1 {
+			if hasTextureId && textureIds[0] != formats.MSRDTextureId(textureId) {
+				fmt.Printf("Note: %s names texture %d (%s), ignoring id number %d\n", fileName, textureIds[0], textureName, textureId)
+			}
+			return textureIds[0], nil
+		}
+		if len(textureIds) > 1 {
+			for _, id := range textureIds {
+				if hasTextureId && id == formats.MSRDTextureId(textureId) {
+					return id, nil
+				}
+			}
+			return 0, errors.New("texture name " + textureName + " is ambiguous, it is shared by ids " + fmt.Sprint(textureIds) +
+				", please use <id.name.dds> naming format with one of them")
+		}
+		if !hasTextureId {
+			return 0, errors.New("unknown texture name " + textureName + ", please use <name.dds> with a name from the wismt or <id.name.dds> naming format")
+		}
+	}
+
+	if !hasTextureId {
+		return 0, errors.New("no texture name or id number found in filename, please use <name.dds> or <id.name.dds> naming format")
+	}
+	return formats.MSRDTextureId(textureId), nil
+}
+
 type TextureReadResult struct {
-	TextureId formats.MSRDTextureId
-	MipsMIBL  formats.MIBL
-	CacheMIBL formats.MIBL
+	TextureId      formats.MSRDTextureId
+	NewTextureName string
+	MipsMIBL       formats.MIBL
+	CacheMIBL      formats.MIBL
 }
 
 type FileReadResult struct {
@@ -214,7 +415,8 @@ type FileReadResult struct {
 }
 
 func ReadTexture(texturePath string, index int, textureId formats.MSRDTextureId,
-	origCacheMIBL formats.MIBL, xbc1Name [0x1C]byte, channel chan *FileReadResult) {
+	origCacheMIBL formats.MIBL, xbc1Name [0x1C]byte, compressionType formats.XBC1CompressionType, level formats.XBC1CompressionLevel,
+	channel chan *FileReadResult) {
 
 	textureFile, err := os.Open(texturePath)
 	defer textureFile.Close()
@@ -250,10 +452,19 @@ func ReadTexture(texturePath string, index int, textureId formats.MSRDTextureId,
 		return
 	}
 
+	if cachedMipLevel+int(origCacheMIBLFooter.MipCount) > len(mips[0]) {
+		channel <- &FileReadResult{Err: errors.New("missing mipmaps"), Path: texturePath}
+		return
+	}
 	cacheMIBL, err := formats.NewMIBL(mips[0][cachedMipLevel:cachedMipLevel+int(origCacheMIBLFooter.MipCount)],
 		origCacheMIBLFooter.Width, origCacheMIBLFooter.Height, ddsHeaderDXT10.DxgiFormat, 0)
+	if err != nil {
+		channel <- &FileReadResult{Err: err, Path: texturePath}
+		return
+	}
 
-	if index == FILE_INDEX_NO_ENTRY {
+	// textures without a file entry only get one when the replacement has more to stream than the cache
+	if index == FILE_INDEX_NO_ENTRY && cachedMipLevel == 0 {
 		channel <- &FileReadResult{
 			Path:      texturePath,
 			FileIndex: index,
@@ -265,14 +476,76 @@ func ReadTexture(texturePath string, index int, textureId formats.MSRDTextureId,
 		return
 	}
 
-	compressedTextureData, err := formats.CompressToXBC1(xbc1Name,
-		furnace.GetSwizzled(mips[0][0], ddsHeader.Width, ddsHeader.Height, ddsHeaderDXT10.DxgiFormat))
+	compressedTextureData, mipsMIBL, err := NewStreamedTexture(mips[0], ddsHeader.Width, ddsHeader.Height, ddsHeaderDXT10.DxgiFormat, xbc1Name,
+		compressionType, level)
+	if err != nil {
+		channel <- &FileReadResult{Err: err, Path: texturePath}
+		return
+	}
+
+	channel <- &FileReadResult{
+		Path:           texturePath,
+		FileIndex:      index,
+		CompressedData: compressedTextureData,
+		TextureReadResult: TextureReadResult{
+			TextureId: textureId,
+			MipsMIBL:  mipsMIBL,
+			CacheMIBL: cacheMIBL,
+		},
+	}
+}
+
+// NewStreamedTexture builds the high-res file holding the first mip and the MIBL holding the rest
+func NewStreamedTexture(mips [][]byte, width, height uint32, format dds.DXGIFormat, xbc1Name [0x1C]byte,
+	compressionType formats.XBC1CompressionType, level formats.XBC1CompressionLevel) (formats.XBC1, formats.MIBL, error) {
+
+	compressedTextureData, err := formats.CompressToXBC1WithLevel(compressionType, level, xbc1Name,
+		furnace.GetSwizzled(mips[0], width, height, format))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mipsMIBL, err := formats.NewMIBL(mips, width, height, format, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return compressedTextureData, mipsMIBL, nil
+}
+
+func ReadNewTexture(texturePath string, index int, textureName string, compressionType formats.XBC1CompressionType,
+	level formats.XBC1CompressionLevel, channel chan *FileReadResult) {
+	textureFile, err := os.Open(texturePath)
+	defer textureFile.Close()
+	if err != nil {
+		channel <- &FileReadResult{Err: err, Path: texturePath}
+		return
+	}
+
+	ddsHeader, ddsHeaderDXT10, mips, err := dds.LoadDDS(textureFile)
 	if err != nil {
 		channel <- &FileReadResult{Err: err, Path: texturePath}
 		return
 	}
+	if len(mips[0]) <= 1 {
+		channel <- &FileReadResult{Err: errors.New("missing mipmaps"), Path: texturePath}
+		return
+	}
 
-	mipsMIBL, err := formats.NewMIBL(mips[0], ddsHeader.Width, ddsHeader.Height, ddsHeaderDXT10.DxgiFormat, 1)
+	cachedMipLevel := 0
+	for cachedMipLevel < len(mips[0])-1 &&
+		(ddsHeader.Width>>cachedMipLevel > NEW_TEXTURE_CACHE_SIZE || ddsHeader.Height>>cachedMipLevel > NEW_TEXTURE_CACHE_SIZE) {
+		cachedMipLevel++
+	}
+	cacheMIBL, err := formats.NewMIBL(mips[0][cachedMipLevel:cachedMipLevel+1],
+		ddsHeader.Width>>cachedMipLevel, ddsHeader.Height>>cachedMipLevel, ddsHeaderDXT10.DxgiFormat, 0)
+	if err != nil {
+		channel <- &FileReadResult{Err: err, Path: texturePath}
+		return
+	}
+
+	compressedTextureData, mipsMIBL, err := NewStreamedTexture(mips[0], ddsHeader.Width, ddsHeader.Height,
+		ddsHeaderDXT10.DxgiFormat, formats.GetMSRDFileName(index), compressionType, level)
 	if err != nil {
 		channel <- &FileReadResult{Err: err, Path: texturePath}
 		return
@@ -283,21 +556,22 @@ func ReadTexture(texturePath string, index int, textureId formats.MSRDTextureId,
 		FileIndex:      index,
 		CompressedData: compressedTextureData,
 		TextureReadResult: TextureReadResult{
-			TextureId: textureId,
-			MipsMIBL:  mipsMIBL,
-			CacheMIBL: cacheMIBL,
+			NewTextureName: textureName,
+			MipsMIBL:       mipsMIBL,
+			CacheMIBL:      cacheMIBL,
 		},
 	}
 }
 
-func ReadRaw(rawPath string, index int, xbc1Name [0x1C]byte, channel chan *FileReadResult) {
+func ReadRaw(rawPath string, index int, xbc1Name [0x1C]byte, compressionType formats.XBC1CompressionType,
+	level formats.XBC1CompressionLevel, channel chan *FileReadResult) {
 	data, err := ioutil.ReadFile(rawPath)
 	if err != nil {
 		channel <- &FileReadResult{Err: err, Path: rawPath}
 		return
 	}
 
-	compressedData, err := formats.CompressToXBC1(xbc1Name, data)
+	compressedData, err := formats.CompressToXBC1WithLevel(compressionType, level, xbc1Name, data)
 	if err != nil {
 		channel <- &FileReadResult{Err: err, Path: rawPath}
 		return
@@ -309,3 +583,59 @@ func ReadRaw(rawPath string, index int, xbc1Name [0x1C]byte, channel chan *FileR
 		CompressedData: compressedData,
 	}
 }
+
+func GetWimdoPath(wismtPath string) string {
+	return strings.TrimSuffix(wismtPath, filepath.Ext(wismtPath)) + ".wimdo"
+}
+
+// ReadWimdo reads the wimdo at inWimdoPath, or the one next to the wismt when no path is given, and makes sure it
+// belongs to the wismt with metaData before anything gets edited
+func ReadWimdo(inWimdoPath, inWismtPath string, metaData formats.MSRDMetaData) (formats.MXMD, error) {
+	wimdoPath := inWimdoPath
+	if wimdoPath == "" {
+		wimdoPath = GetWimdoPath(inWismtPath)
+	}
+	fmt.Printf("Reading wimdo file: %s...\n", wimdoPath)
+	inWimdoFile, err := os.Open(wimdoPath)
+	defer inWimdoFile.Close()
+	if err != nil {
+		if inWimdoPath != "" {
+			return nil, err
+		}
+		return nil, errors.New(err.Error() + "\nMake sure you place it in the same directory as the wismt file")
+	}
+	wimdo, err := formats.ReadMXMD(inWimdoFile)
+	if err != nil {
+		return nil, errors.New("Could not read wimdo file: " + err.Error())
+	}
+	wimdoHeader, err := wimdo.GetHeader()
+	if err != nil {
+		return nil, errors.New("Could not read wimdo header: " + err.Error())
+	}
+	if wimdoHeader.UncachedTexturesOffset == 0 {
+		return nil, errors.New("Could not find uncached textures offset in wimdo file")
+	}
+	if err := wimdo.CheckUncachedTextures(metaData); err != nil {
+		return nil, errors.New(err.Error() + "\nMake sure the wimdo and the wismt come from the same model")
+	}
+	return wimdo, nil
+}
+
+// SaveWimdo embeds the updated wismt metadata in the wimdo and saves it to outWimdoPath, or next to the output wismt
+// when no path is given. origMetaData is the wismt metadata before editing.
+func SaveWimdo(wimdo formats.MXMD, origMetaData, metaData formats.MSRDMetaData, outWimdoPath, outWismtPath string) error {
+	wimdoPath := outWimdoPath
+	if wimdoPath == "" {
+		wimdoPath = GetWimdoPath(outWismtPath)
+	}
+	fmt.Printf("Saving wimdo file: %s...\n", wimdoPath)
+	if err := wimdo.SetUncachedTextures(origMetaData, metaData); err != nil {
+		return errors.New("Could not update wimdo: " + err.Error())
+	}
+	outWimdoFile, err := os.Create(wimdoPath)
+	defer outWimdoFile.Close()
+	if err != nil {
+		return err
+	}
+	return formats.WriteMXMD(outWimdoFile, wimdo)
+}
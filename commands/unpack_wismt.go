@@ -0,0 +1,242 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/3096/furnace/furnace/formats"
+)
+
+const MANIFEST_FILE_NAME = "manifest.json"
+const UNPACKED_FILES_DIR = "files"
+const UNPACKED_XBC1_DIR = "xbc1"
+
+// WismtManifest holds everything besides the file contents needed to pack an unpacked wismt again
+type WismtManifest struct {
+	Header     formats.MSRDHeader
+	MetaHeader formats.MSRDMetaDataHeader
+	// bytes between the meta header and the data items table
+	MetaHeaderExtra   []byte
+	DataItems         []DataItemInfo
+	Files             []ManifestFile
+	TextureIds        []formats.MSRDTextureId
+	TextureInfoHeader formats.MSRDTextureInfoHeader
+	Textures          []ManifestTexture
+}
+
+type ManifestFile struct {
+	Index int
+	formats.MSRDFileItem
+	XBC1Name            string
+	XBC1Hash            uint32
+	XBC1CompressionType formats.XBC1CompressionType
+	// decompressed content, relative to the unpacked directory
+	Path string
+	// original xbc1, reused as is while the decompressed content still matches Sha256. it's only a cache, the file is
+	// compressed again from Path when it's missing
+	XBC1Path string
+	Sha256   string
+}
+
+type ManifestTexture struct {
+	Id   formats.MSRDTextureId
+	Name string
+	formats.MSRDTextureInfoItem
+}
+
+// UnpackWismt decompresses every file of the wismt to <out dir>/files and writes the manifest describing them
+func UnpackWismt(inWismtPath, outDir string) error {
+	fmt.Printf("Reading wismt file: %s...\n", inWismtPath)
+	inWismtFile, err := os.Open(inWismtPath)
+	defer inWismtFile.Close()
+	if err != nil {
+		return err
+	}
+	wismt, err := formats.ReadMSRD(inWismtFile)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{UNPACKED_FILES_DIR, UNPACKED_XBC1_DIR} {
+		if err := os.MkdirAll(filepath.Join(outDir, dir), 0755); err != nil {
+			return err
+		}
+	}
+
+	manifest := WismtManifest{
+		Header:            wismt.Header,
+		MetaHeader:        wismt.MetaHeader,
+		TextureIds:        make([]formats.MSRDTextureId, len(wismt.TextureIdToIndexMap)),
+		TextureInfoHeader: wismt.TextureInfoHeader,
+	}
+	metaHeaderSize := uint32(binary.Size(&wismt.MetaHeader))
+	if wismt.MetaHeader.DataItemsTableOffset > metaHeaderSize && int(wismt.MetaHeader.DataItemsTableOffset) <= len(wismt.MetaData) {
+		manifest.MetaHeaderExtra = wismt.MetaData[metaHeaderSize:wismt.MetaHeader.DataItemsTableOffset]
+	}
+	for i, dataItem := range wismt.DataItems {
+		manifest.DataItems = append(manifest.DataItems, DataItemInfo{Index: i, TypeName: dataItem.Type.String(), MSRDDataItem: dataItem})
+	}
+	for id, index := range wismt.TextureIdToIndexMap {
+		manifest.TextureIds[index] = id
+	}
+	for i, textureInfoItem := range wismt.TextureInfoItems {
+		manifest.Textures = append(manifest.Textures, ManifestTexture{
+			Id:                  formats.MSRDTextureId(i),
+			Name:                wismt.TextureNames[i],
+			MSRDTextureInfoItem: textureInfoItem,
+		})
+	}
+
+	for i, compressedFile := range wismt.CompressedFiles {
+		xbc1Header, data, err := formats.ExtractXBC1(bytes.NewReader(compressedFile))
+		if err != nil {
+			return errors.New("Error extracting file " + fmt.Sprint(i) + ": " + err.Error())
+		}
+
+		manifestFile := ManifestFile{
+			Index:               i,
+			MSRDFileItem:        wismt.FileItems[i],
+			XBC1Name:            xbc1Header.GetName(),
+			XBC1Hash:            xbc1Header.Hash,
+			XBC1CompressionType: xbc1Header.CompressionType,
+			Path:                filepath.ToSlash(filepath.Join(UNPACKED_FILES_DIR, fmt.Sprintf("%04d.bin", i))),
+			XBC1Path:            filepath.ToSlash(filepath.Join(UNPACKED_XBC1_DIR, fmt.Sprintf("%04d.xbc1", i))),
+			Sha256:              getSha256String(data),
+		}
+		if err := ioutil.WriteFile(filepath.Join(outDir, manifestFile.Path), data, 0644); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(outDir, manifestFile.XBC1Path), compressedFile, 0644); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, manifestFile)
+		fmt.Printf("Successfully unpacked file%d (%s)\n", i, manifestFile.XBC1Name)
+	}
+
+	manifestJson, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.New("Error encoding manifest: " + err.Error())
+	}
+	if err := ioutil.WriteFile(filepath.Join(outDir, MANIFEST_FILE_NAME), append(manifestJson, '\n'), 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Done: unpacked %d files, output: %s\n", len(manifest.Files), outDir)
+	return nil
+}
+
+// PackWismt builds a wismt from a directory written by UnpackWismt. Files whose content didn't change keep their
+// original compressed data, so packing an untouched directory gives back the original wismt.
+func PackWismt(inDir, outWismtPath string) error {
+	manifestJson, err := ioutil.ReadFile(filepath.Join(inDir, MANIFEST_FILE_NAME))
+	if err != nil {
+		return err
+	}
+	var manifest WismtManifest
+	if err := json.Unmarshal(manifestJson, &manifest); err != nil {
+		return errors.New("Error decoding manifest: " + err.Error())
+	}
+
+	wismt := formats.MSRD{
+		Header:              manifest.Header,
+		MetaHeader:          manifest.MetaHeader,
+		TextureIdToIndexMap: make(map[formats.MSRDTextureId]int),
+		TextureInfoHeader:   manifest.TextureInfoHeader,
+	}
+	metaHeaderSize := binary.Size(&wismt.MetaHeader)
+	wismt.MetaData = append(make(formats.MSRDMetaData, metaHeaderSize), manifest.MetaHeaderExtra...)
+	wismt.MetaHeader.DataItemsTableOffset = uint32(len(wismt.MetaData))
+
+	for _, dataItem := range manifest.DataItems {
+		wismt.DataItems = append(wismt.DataItems, dataItem.MSRDDataItem)
+	}
+	for index, id := range manifest.TextureIds {
+		if _, found := wismt.TextureIdToIndexMap[id]; found {
+			return errors.New("Texture id " + fmt.Sprint(id) + " is listed more than once in the manifest")
+		}
+		wismt.TextureIdToIndexMap[id] = index
+	}
+	for _, texture := range manifest.Textures {
+		wismt.TextureInfoItems = append(wismt.TextureInfoItems, texture.MSRDTextureInfoItem)
+		wismt.TextureNames = append(wismt.TextureNames, texture.Name)
+	}
+
+	for i, manifestFile := range manifest.Files {
+		if manifestFile.Index != i {
+			return errors.New("Manifest files are out of order at file " + fmt.Sprint(i))
+		}
+		compressedFile, isOriginal, err := packManifestFile(inDir, manifestFile)
+		if err != nil {
+			return errors.New("Error packing file " + fmt.Sprint(i) + ": " + err.Error())
+		}
+		wismt.CompressedFiles = append(wismt.CompressedFiles, compressedFile)
+		if !isOriginal {
+			wismt.SetCompressedFileData(i, compressedFile)
+		}
+	}
+
+	fmt.Printf("Saving wismt file: %s...\n", outWismtPath)
+	outWismtFile, err := os.Create(outWismtPath)
+	defer outWismtFile.Close()
+	if err != nil {
+		return err
+	}
+	if err := formats.WriteMSRD(outWismtFile, wismt); err != nil {
+		return err
+	}
+
+	fmt.Printf("Done: packed %d files, output: %s\n", len(wismt.CompressedFiles), outWismtPath)
+	return nil
+}
+
+// packManifestFile returns the original xbc1 of the file, already padded, or the changed content compressed again
+func packManifestFile(inDir string, manifestFile ManifestFile) (formats.XBC1, bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(inDir, filepath.FromSlash(manifestFile.Path)))
+	if err != nil {
+		return nil, false, err
+	}
+	var xbc1Name [0x1C]byte
+	if len(manifestFile.XBC1Name) > len(xbc1Name) {
+		return nil, false, errors.New("xbc1 name " + manifestFile.XBC1Name + " is too long")
+	}
+	copy(xbc1Name[:], manifestFile.XBC1Name)
+
+	if manifestFile.XBC1Path != "" && getSha256String(data) == manifestFile.Sha256 {
+		compressedFile, err := ioutil.ReadFile(filepath.Join(inDir, filepath.FromSlash(manifestFile.XBC1Path)))
+		if err == nil {
+			compressedFile := formats.XBC1(compressedFile)
+			if _, err := formats.ReadXBC1Header(bytes.NewReader(compressedFile)); err != nil {
+				return nil, false, err
+			}
+			compressedFile.SetName(xbc1Name)
+			return compressedFile, true, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, false, err
+		}
+	}
+
+	fmt.Printf("Compressing changed file%d (%s)...\n", manifestFile.Index, manifestFile.XBC1Name)
+	compressionType := manifestFile.XBC1CompressionType
+	if compressionType == 0 {
+		compressionType = formats.XBC1_COMPRESSION_TYPE_ZLIB
+	}
+	compressedFile, err := formats.CompressToXBC1WithType(compressionType, xbc1Name, data)
+	if err != nil {
+		return nil, false, err
+	}
+	return compressedFile, false, nil
+}
+
+func getSha256String(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/3096/furnace/furnace/formats"
+)
+
+// ValidateWismt checks a wismt and its wimdo against each other: everything MSRD.Validate checks in the wismt, the
+// metadata copy in the wimdo and the textures its materials use. The problems found are returned, err is only set
+// when either file can't be read at all. Without an explicit wimdo path, the wimdo next to the wismt is used.
+func ValidateWismt(inWismtPath, inWimdoPath string) ([]error, error) {
+	inWismtFile, err := os.Open(inWismtPath)
+	defer inWismtFile.Close()
+	if err != nil {
+		return nil, err
+	}
+	wismt, err := formats.ReadMSRD(inWismtFile)
+	if err != nil {
+		return nil, errors.New("Could not read wismt file: " + err.Error())
+	}
+
+	wimdoPath := inWimdoPath
+	if wimdoPath == "" {
+		wimdoPath = GetWimdoPath(inWismtPath)
+	}
+	inWimdoFile, err := os.Open(wimdoPath)
+	defer inWimdoFile.Close()
+	if err != nil {
+		return nil, err
+	}
+	wimdo, err := formats.ReadMXMD(inWimdoFile)
+	if err != nil {
+		return nil, errors.New("Could not read wimdo file: " + err.Error())
+	}
+
+	problems := wismt.Validate()
+	if err := wimdo.CheckUncachedTextures(wismt.MetaData); err != nil {
+		problems = append(problems, err)
+	}
+	wimdoFile, err := formats.ParseMXMD(wimdo)
+	if err != nil {
+		return append(problems, errors.New("Could not parse wimdo: "+err.Error())), nil
+	}
+	if wimdoFile.Materials != nil {
+		for _, material := range wimdoFile.Materials.Materials {
+			for _, texture := range material.Textures {
+				if int(texture.TextureIndex) >= len(wismt.TextureInfoItems) {
+					problems = append(problems, errors.New("Material "+material.Name+" uses texture "+
+						fmt.Sprint(texture.TextureIndex)+", the wismt has "+fmt.Sprint(len(wismt.TextureInfoItems))))
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+// PrintWismtValidation writes every problem ValidateWismt finds, and fails when there is any
+func PrintWismtValidation(writer io.Writer, inWismtPath, inWimdoPath string) error {
+	problems, err := ValidateWismt(inWismtPath, inWimdoPath)
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(writer, problem)
+	}
+	if len(problems) > 0 {
+		return errors.New("Found " + fmt.Sprint(len(problems)) + " problems in " + inWismtPath)
+	}
+	fmt.Fprintf(writer, "No problems found in %s\n", inWismtPath)
+	return nil
+}
@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/3096/furnace/dds"
+	"github.com/3096/furnace/furnace/formats"
+)
+
+type WismtInfo struct {
+	Header              formats.MSRDHeader
+	MetaHeader          formats.MSRDMetaDataHeader
+	DataItems           []DataItemInfo
+	Files               []FileInfo
+	TextureIdToIndexMap map[formats.MSRDTextureId]int
+	TextureInfoHeader   formats.MSRDTextureInfoHeader
+	Textures            []TextureInfo
+	WimdoHeader         *formats.MXMDHeader `json:",omitempty"`
+	Wimdo               *formats.MXMDFile   `json:",omitempty"`
+	WimdoError          string              `json:",omitempty"`
+}
+
+type DataItemInfo struct {
+	Index    int
+	TypeName string
+	formats.MSRDDataItem
+}
+
+type FileInfo struct {
+	Index int
+	formats.MSRDFileItem
+	XBC1Name            string
+	XBC1Hash            uint32
+	XBC1CompressionType string
+}
+
+type TextureInfo struct {
+	Id        formats.MSRDTextureId
+	Name      string
+	UsageName string
+	formats.MSRDTextureInfoItem
+	CacheFooter     *formats.MIBLFooter `json:",omitempty"`
+	CacheFormatName string              `json:",omitempty"`
+	CacheError      string              `json:",omitempty"`
+}
+
+func GetWismtInfo(wismt *formats.MSRD) WismtInfo {
+	info := WismtInfo{
+		Header:              wismt.Header,
+		MetaHeader:          wismt.MetaHeader,
+		TextureIdToIndexMap: wismt.TextureIdToIndexMap,
+		TextureInfoHeader:   wismt.TextureInfoHeader,
+	}
+
+	for i, dataItem := range wismt.DataItems {
+		info.DataItems = append(info.DataItems, DataItemInfo{
+			Index:        i,
+			TypeName:     dataItem.Type.String(),
+			MSRDDataItem: dataItem,
+		})
+	}
+
+	for i, fileItem := range wismt.FileItems {
+		fileInfo := FileInfo{Index: i, MSRDFileItem: fileItem}
+		if xbc1Header, err := formats.ReadXBC1Header(bytes.NewReader(wismt.CompressedFiles[i])); err == nil {
+			fileInfo.XBC1Name = xbc1Header.GetName()
+			fileInfo.XBC1Hash = xbc1Header.Hash
+			fileInfo.XBC1CompressionType = xbc1Header.CompressionType.String()
+		}
+		info.Files = append(info.Files, fileInfo)
+	}
+
+	cachedTextures, cachedTexturesErr := wismt.GetCachedTextures()
+	for i, textureInfoItem := range wismt.TextureInfoItems {
+		textureInfo := TextureInfo{
+			Id:                  formats.MSRDTextureId(i),
+			Name:                wismt.TextureNames[i],
+			UsageName:           textureInfoItem.Usage.String(),
+			MSRDTextureInfoItem: textureInfoItem,
+		}
+		if cachedTexturesErr != nil {
+			textureInfo.CacheError = cachedTexturesErr.Error()
+		} else if cacheFooter, err := cachedTextures[i].GetFooter(); err != nil {
+			textureInfo.CacheError = err.Error()
+		} else {
+			textureInfo.CacheFooter = &cacheFooter
+			if format, found := formats.MIBLFormatToDXGIFormat[cacheFooter.Format]; found {
+				textureInfo.CacheFormatName = dds.DXGI_FORMAT_INFO_MAP[format].Name
+			}
+		}
+		info.Textures = append(info.Textures, textureInfo)
+	}
+
+	return info
+}
+
+// PrintWismtInfo writes everything parsed from the wismt and its wimdo as JSON. Without an explicit
+// wimdo path, the wimdo next to the wismt is used if there is one.
+func PrintWismtInfo(writer io.Writer, inWismtPath, inWimdoPath string) error {
+	inWismtFile, err := os.Open(inWismtPath)
+	defer inWismtFile.Close()
+	if err != nil {
+		return err
+	}
+	wismt, err := formats.ReadMSRD(inWismtFile)
+	if err != nil {
+		return err
+	}
+	info := GetWismtInfo(&wismt)
+
+	wimdoPath := inWimdoPath
+	if wimdoPath == "" {
+		wimdoPath = GetWimdoPath(inWismtPath)
+	}
+	inWimdoFile, err := os.Open(wimdoPath)
+	defer inWimdoFile.Close()
+	if err == nil {
+		wimdo, err := formats.ReadMXMD(inWimdoFile)
+		if err != nil {
+			return errors.New("Could not read wimdo file: " + err.Error())
+		}
+		info.WimdoHeader, err = wimdo.GetHeader()
+		if err != nil {
+			return errors.New("Could not read wimdo header: " + err.Error())
+		}
+		// the header is enough to be useful, a wimdo whose sections can't be parsed still gets printed
+		if wimdoFile, err := formats.ParseMXMD(wimdo); err == nil {
+			info.Wimdo = &wimdoFile
+		} else {
+			info.WimdoError = err.Error()
+		}
+	} else if inWimdoPath != "" || !os.IsNotExist(err) {
+		return err
+	}
+
+	jsonEncoder := json.NewEncoder(writer)
+	jsonEncoder.SetIndent("", "  ")
+	if err := jsonEncoder.Encode(info); err != nil {
+		return errors.New("Error encoding info: " + fmt.Sprint(err))
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/3096/furnace/furnace/formats"
+)
+
+// paths given as this read from stdin or write to stdout
+const STDIO_PATH = "-"
+
+type XBC1Options struct {
+	// name stored in the xbc1, defaults to the input file name without extension
+	Name string
+	// take the name from this xbc1 file instead, to wrap data extracted from it again
+	NameFromPath string
+	// defaults to the type of the NameFromPath file, or zlib
+	CompressionType  formats.XBC1CompressionType
+	CompressionLevel formats.XBC1CompressionLevel
+}
+
+// DecompressXBC1 writes the decompressed content of an xbc1 file as it's decompressed. Progress goes to stderr so
+// stdout can be piped.
+func DecompressXBC1(inPath, outPath string) error {
+	inFile, err := openInput(inPath)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+	header, xbc1Reader, err := formats.NewXBC1Reader(inFile)
+	if err != nil {
+		return err
+	}
+	defer xbc1Reader.Close()
+
+	outFile, err := createOutput(outPath)
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(outFile, xbc1Reader)
+	if err := finishOutput(outFile, outPath, err); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Decompressed %s (%s, %d bytes)\n", header.GetName(), header.CompressionType, size)
+	return nil
+}
+
+// CompressXBC1 wraps a file in an xbc1. Progress goes to stderr so stdout can be piped.
+func CompressXBC1(inPath, outPath string, options XBC1Options) error {
+	var name [0x1C]byte
+	compressionType := options.CompressionType
+	if options.NameFromPath != "" {
+		nameFromFile, err := os.Open(options.NameFromPath)
+		if err != nil {
+			return err
+		}
+		defer nameFromFile.Close()
+		header, err := formats.ReadXBC1Header(nameFromFile)
+		if err != nil {
+			return err
+		}
+		name = header.Name
+		if compressionType == 0 {
+			compressionType = header.CompressionType
+		}
+	} else {
+		nameString := options.Name
+		if nameString == "" && inPath != STDIO_PATH {
+			nameString = strings.TrimSuffix(filepath.Base(inPath), filepath.Ext(inPath))
+		}
+		if len(nameString) > len(name) {
+			return errors.New("xbc1 name " + nameString + " is too long, it has to fit in " + fmt.Sprint(len(name)) + " bytes")
+		}
+		copy(name[:], nameString)
+	}
+	if compressionType == 0 {
+		compressionType = formats.XBC1_COMPRESSION_TYPE_ZLIB
+	}
+
+	inFile, err := openInput(inPath)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	var header formats.XBC1Header
+	if outPath == STDIO_PATH {
+		// stdout can't go back to fill in the header, the xbc1 is built in memory instead
+		data, err := ioutil.ReadAll(inFile)
+		if err != nil {
+			return err
+		}
+		compressedData, err := formats.CompressToXBC1WithLevel(compressionType, options.CompressionLevel, name, data)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stdout.Write(compressedData); err != nil {
+			return err
+		}
+		header, err = formats.ReadXBC1Header(bytes.NewReader(compressedData))
+		if err != nil {
+			return err
+		}
+	} else {
+		outFile, err := os.Create(outPath)
+		if err != nil {
+			return err
+		}
+		header, err = writeXBC1(outFile, inFile, compressionType, options.CompressionLevel, name)
+		if err := finishOutput(outFile, outPath, err); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Compressed %s (%s, %d bytes)\n", header.GetName(), compressionType, header.UncompressedSize)
+	return nil
+}
+
+func writeXBC1(outFile io.WriteSeeker, inFile io.Reader, compressionType formats.XBC1CompressionType,
+	level formats.XBC1CompressionLevel, name [0x1C]byte) (formats.XBC1Header, error) {
+	xbc1Writer, err := formats.NewXBC1Writer(outFile, compressionType, level, name)
+	if err != nil {
+		return formats.XBC1Header{}, err
+	}
+	if _, err := io.Copy(xbc1Writer, inFile); err != nil {
+		return formats.XBC1Header{}, err
+	}
+	if err := xbc1Writer.Close(); err != nil {
+		return formats.XBC1Header{}, err
+	}
+	return xbc1Writer.Header(), nil
+}
+
+func ParseXBC1CompressionType(name string) (formats.XBC1CompressionType, error) {
+	for compressionType, compressionTypeName := range formats.XBC1CompressionTypeNames {
+		if compressionTypeName == name {
+			return compressionType, nil
+		}
+	}
+	return 0, errors.New("unknown xbc1 compression type " + name)
+}
+
+// ParseXBC1CompressionLevel takes a level name (fast, default, best) or number
+func ParseXBC1CompressionLevel(name string) (formats.XBC1CompressionLevel, error) {
+	for level, levelName := range formats.XBC1CompressionLevelNames {
+		if levelName == name {
+			return level, nil
+		}
+	}
+	level, err := strconv.Atoi(name)
+	if err != nil || formats.XBC1CompressionLevel(level) < formats.XBC1_COMPRESSION_LEVEL_FAST ||
+		formats.XBC1CompressionLevel(level) > formats.XBC1_COMPRESSION_LEVEL_BEST {
+		return 0, errors.New("unknown xbc1 compression level " + name + ", expected fast, default, best or " +
+			fmt.Sprint(int(formats.XBC1_COMPRESSION_LEVEL_FAST)) + " to " + fmt.Sprint(int(formats.XBC1_COMPRESSION_LEVEL_BEST)))
+	}
+	return formats.XBC1CompressionLevel(level), nil
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == STDIO_PATH {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == STDIO_PATH {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+// finishOutput closes an output file and returns the first error of writing and closing it. On error the file is
+// removed, so no partial output is left behind.
+func finishOutput(outFile io.Closer, path string, err error) error {
+	if closeErr := outFile.Close(); err == nil && closeErr != nil {
+		err = errors.New("Error closing " + path + ": " + closeErr.Error())
+	}
+	if err != nil && path != STDIO_PATH {
+		os.Remove(path)
+	}
+	return err
+}
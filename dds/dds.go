@@ -47,7 +47,49 @@ type DDSPixelFormat struct {
 	ABitMask    uint32
 }
 
+const (
+	DDSD_CAPS        uint32 = 0x1
+	DDSD_HEIGHT      uint32 = 0x2
+	DDSD_WIDTH       uint32 = 0x4
+	DDSD_PITCH       uint32 = 0x8
+	DDSD_PIXELFORMAT uint32 = 0x1000
+	DDSD_MIPMAPCOUNT uint32 = 0x20000
+	DDSD_LINEARSIZE  uint32 = 0x80000
+	DDSD_DEPTH       uint32 = 0x800000
+)
+
+const (
+	DDPF_ALPHAPIXELS uint32 = 0x1
+	DDPF_FOURCC      uint32 = 0x4
+	DDPF_RGB         uint32 = 0x40
+)
+
+const (
+	DDSCAPS_COMPLEX uint32 = 0x8
+	DDSCAPS_TEXTURE uint32 = 0x1000
+	DDSCAPS_MIPMAP  uint32 = 0x400000
+)
+
+const DDS_DIMENSION_TEXTURE2D uint32 = 3
+
 var DX10_FORMAT = [4]byte{'D', 'X', '1', '0'}
+var LEGACY_PIXEL_FORMAT_MAP = map[DXGIFormat]DDSPixelFormat{
+	DXGI_FORMAT_R8G8B8A8_UNORM: {
+		Size:        32,
+		Flags:       DDPF_RGB | DDPF_ALPHAPIXELS,
+		RGBBitCount: 32,
+		RBitMask:    0x000000ff,
+		GBitMask:    0x0000ff00,
+		BBitMask:    0x00ff0000,
+		ABitMask:    0xff000000,
+	},
+	DXGI_FORMAT_BC1_UNORM: {Size: 32, Flags: DDPF_FOURCC, FourCC: [4]byte{'D', 'X', 'T', '1'}},
+	DXGI_FORMAT_BC2_UNORM: {Size: 32, Flags: DDPF_FOURCC, FourCC: [4]byte{'D', 'X', 'T', '3'}},
+	DXGI_FORMAT_BC3_UNORM: {Size: 32, Flags: DDPF_FOURCC, FourCC: [4]byte{'D', 'X', 'T', '5'}},
+	DXGI_FORMAT_BC4_UNORM: {Size: 32, Flags: DDPF_FOURCC, FourCC: [4]byte{'A', 'T', 'I', '1'}},
+	DXGI_FORMAT_BC5_UNORM: {Size: 32, Flags: DDPF_FOURCC, FourCC: [4]byte{'A', 'T', 'I', '2'}},
+}
+
 var NON_DX10_FORMAT_MAP = map[[4]byte]DXGIFormat{
 	{0, 0, 0, 0}:         DXGI_FORMAT_R8G8B8A8_UNORM,
 	{'D', 'X', 'T', '1'}: DXGI_FORMAT_BC1_UNORM,
@@ -130,6 +172,94 @@ func LoadDDS(ddsFileReader io.Reader) (DDSHeader, DDSHeaderDXT10, [][][]byte, er
 	return header, headerDXT10, surfaces, nil
 }
 
+// WriteDDS fills in size, flags, pitch, mip count, pixel format and caps of the header from the surfaces and the
+// DXGI format in headerDXT10, only Width and Height need to be set. Formats with a legacy FourCC are written without
+// the DX10 header unless there is more than one surface in the array.
+func WriteDDS(ddsFileWriter io.Writer, header DDSHeader, headerDXT10 DDSHeaderDXT10, surfaces [][][]byte) error {
+	byteOrder := utils.NativeByteOrder()
+
+	dxgiFormatInfo, dxgiFormatInfoSupported := DXGI_FORMAT_INFO_MAP[headerDXT10.DxgiFormat]
+	if !dxgiFormatInfoSupported {
+		return errors.New("Unsupported DXGI format: " + fmt.Sprint(headerDXT10.DxgiFormat))
+	}
+	if len(surfaces) == 0 || len(surfaces[0]) == 0 {
+		return errors.New("No surfaces to write to dds file")
+	}
+	if header.Width == 0 || header.Height == 0 {
+		return errors.New("Invalid DDS dimensions: " + fmt.Sprintf("%dx%d", header.Width, header.Height))
+	}
+
+	mipMapCount := len(surfaces[0])
+	for i := range surfaces {
+		if len(surfaces[i]) != mipMapCount {
+			return errors.New("Mipmap count mismatch in surface " + fmt.Sprint(i))
+		}
+		w := header.Width
+		h := header.Height
+		for mipmapLevel := range surfaces[i] {
+			if len(surfaces[i][mipmapLevel]) != int(dxgiFormatInfo.GetSurfaceSize(w, h)) {
+				return errors.New("Invalid data size for mipmap level " + fmt.Sprint(mipmapLevel) + " of surface " + fmt.Sprint(i))
+			}
+			w /= 2
+			h /= 2
+		}
+	}
+
+	header.Size = 124
+	header.Flags = DDSD_CAPS | DDSD_HEIGHT | DDSD_WIDTH | DDSD_PIXELFORMAT | DDSD_MIPMAPCOUNT
+	header.MipMapCount = uint32(mipMapCount)
+	if header.Depth == 0 {
+		header.Depth = 1
+	}
+	if dxgiFormatInfo.BlockSideLen > 1 {
+		header.Flags |= DDSD_LINEARSIZE
+		header.PitchOrLinearSize = uint32(len(surfaces[0][0]))
+	} else {
+		header.Flags |= DDSD_PITCH
+		header.PitchOrLinearSize = (header.Width*dxgiFormatInfo.BitsPerPixel + 7) / 8
+	}
+	header.Caps = DDSCAPS_TEXTURE
+	if mipMapCount > 1 {
+		header.Caps |= DDSCAPS_COMPLEX | DDSCAPS_MIPMAP
+	}
+
+	legacyPixelFormat, hasLegacyPixelFormat := LEGACY_PIXEL_FORMAT_MAP[headerDXT10.DxgiFormat]
+	writeDX10Header := !hasLegacyPixelFormat || len(surfaces) > 1
+	if writeDX10Header {
+		header.Dddpf = DDSPixelFormat{
+			Size:   32,
+			Flags:  DDPF_FOURCC,
+			FourCC: DX10_FORMAT,
+		}
+		headerDXT10.ResourceDimension = DDS_DIMENSION_TEXTURE2D
+		headerDXT10.ArraySize = uint32(len(surfaces))
+	} else {
+		header.Dddpf = legacyPixelFormat
+	}
+
+	if _, err := ddsFileWriter.Write(MAGIC[:]); err != nil {
+		return errors.New("Error when writing dds file: " + err.Error())
+	}
+	if err := binary.Write(ddsFileWriter, byteOrder, &header); err != nil {
+		return errors.New("Error when writing dds file: " + err.Error())
+	}
+	if writeDX10Header {
+		if err := binary.Write(ddsFileWriter, byteOrder, &headerDXT10); err != nil {
+			return errors.New("Error when writing dds file: " + err.Error())
+		}
+	}
+
+	for i := range surfaces {
+		for mipmapLevel := range surfaces[i] {
+			if _, err := ddsFileWriter.Write(surfaces[i][mipmapLevel]); err != nil {
+				return errors.New("Error when writing dds file: " + err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
 type DXGIFormat uint32
 
 const (
@@ -261,6 +391,16 @@ type DxgiFormatInfo struct {
 	BlockSideLen uint32
 }
 
+func (formatInfo DxgiFormatInfo) GetSurfaceSize(width, height uint32) uint32 {
+	if width == 0 {
+		width = 1
+	}
+	if height == 0 {
+		height = 1
+	}
+	return utils.Align(width, formatInfo.BlockSideLen) * utils.Align(height, formatInfo.BlockSideLen) * formatInfo.BitsPerPixel / 8
+}
+
 var DXGI_FORMAT_INFO_MAP = map[DXGIFormat]DxgiFormatInfo{
 	DXGI_FORMAT_R8G8B8A8_UNORM: {
 		Name:         "R8G8B8A8_UNORM",
@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"math/bits"
 	"unsafe"
 
 	"github.com/3096/furnace/dds"
@@ -18,20 +20,26 @@ const MIBL_ALIGN_SIZE uint32 = 0x1000
 const MIBL_MIN_WIDTH uint32 = 16
 const MIBL_MIN_HEIGHT uint32 = 32
 
-// I guessed some of these, might not be entirely correct
+// largest width or height a texture of the games is expected to have
+const MIBL_MAX_SIZE uint32 = 16384
+
+// I guessed some of these, might not be entirely correct. Depth and ViewDimension used to be read as the size of the
+// last mip, but every game file has 1 and 1 there whatever the size.
 type MIBLFooter struct {
 	DataSize      uint32
 	AlignSize     uint32
 	Width         uint32
 	Height        uint32
-	LastMipWidth  uint32
-	LastMipHeight uint32
+	Depth         uint32
+	ViewDimension uint32
 	Format        MIBLFormat
 	MipCount      uint32
 	Version       uint32
 	Magic         uint32
 }
 
+const MIBL_VIEW_DIMENSION_2D uint32 = 1
+
 type MIBL []byte
 
 type MIBLFormat uint32
@@ -56,6 +64,16 @@ var DXGIFormatToMIBLFormat = map[dds.DXGIFormat]MIBLFormat{
 	dds.DXGI_FORMAT_BC7_UNORM:      MIBL_FORMAT_BC7_UNORM,
 }
 
+var MIBLFormatToDXGIFormat = map[MIBLFormat]dds.DXGIFormat{
+	MIBL_FORMAT_R8G8B8A8_UNORM: dds.DXGI_FORMAT_R8G8B8A8_UNORM,
+	MIBL_FORMAT_BC1_UNORM:      dds.DXGI_FORMAT_BC1_UNORM,
+	MIBL_FORMAT_BC2_UNORM:      dds.DXGI_FORMAT_BC2_UNORM,
+	MIBL_FORMAT_BC3_UNORM:      dds.DXGI_FORMAT_BC3_UNORM,
+	MIBL_FORMAT_BC4_UNORM:      dds.DXGI_FORMAT_BC4_UNORM,
+	MIBL_FORMAT_BC5_UNORM:      dds.DXGI_FORMAT_BC5_UNORM,
+	MIBL_FORMAT_BC7_UNORM:      dds.DXGI_FORMAT_BC7_UNORM,
+}
+
 func max(a, b uint32) uint32 {
 	if a > b {
 		return a
@@ -106,8 +124,8 @@ func NewMIBL(mipData [][]byte, width, height uint32, format dds.DXGIFormat, star
 		AlignSize:     MIBL_ALIGN_SIZE,
 		Width:         width >> startingIndex,
 		Height:        height >> startingIndex,
-		LastMipWidth:  curMipWidth,
-		LastMipHeight: curMipHeight,
+		Depth:         1,
+		ViewDimension: MIBL_VIEW_DIMENSION_2D,
 		Format:        miblFormat,
 		MipCount:      uint32(len(mipData) - startingIndex),
 		Version:       MIBL_VERSION,
@@ -130,3 +148,104 @@ func (mibl *MIBL) GetFooter() (MIBLFooter, error) {
 	}
 	return footer, nil
 }
+
+// Validate checks the footer describes the data it ends, with dimensions the games can use
+func (mibl *MIBL) Validate() error {
+	footer, err := mibl.GetFooter()
+	if err != nil {
+		return err
+	}
+	if footer.Magic != MIBL_MAGIC {
+		return errors.New("Invalid MIBL magic")
+	}
+	if footer.Version != MIBL_VERSION {
+		return errors.New("Unsupported MIBL version: " + fmt.Sprint(footer.Version))
+	}
+	if footer.AlignSize != MIBL_ALIGN_SIZE || uint32(len(*mibl))%MIBL_ALIGN_SIZE != 0 {
+		return errors.New("MIBL of " + fmt.Sprint(len(*mibl)) + " bytes with alignment " + fmt.Sprint(footer.AlignSize) +
+			" isn't aligned to " + fmt.Sprint(MIBL_ALIGN_SIZE))
+	}
+	if footer.DataSize != uint32(len(*mibl)) {
+		return errors.New("MIBL data size " + fmt.Sprint(footer.DataSize) + " doesn't match its " + fmt.Sprint(len(*mibl)) + " bytes")
+	}
+	if footer.Depth > 1 || footer.ViewDimension != MIBL_VIEW_DIMENSION_2D {
+		return errors.New("Unsupported MIBL dimension, only 2D textures are supported")
+	}
+	if footer.Width == 0 || footer.Height == 0 || footer.Width > MIBL_MAX_SIZE || footer.Height > MIBL_MAX_SIZE ||
+		footer.MipCount == 0 || footer.MipCount > uint32(bits.Len32(max(footer.Width, footer.Height))) {
+		return errors.New("Invalid MIBL size: " + fmt.Sprintf("%dx%d, %d mips", footer.Width, footer.Height, footer.MipCount))
+	}
+	if _, found := MIBLFormatToDXGIFormat[footer.Format]; !found {
+		return errors.New("Unsupported MIBL format: " + fmt.Sprint(footer.Format))
+	}
+	return nil
+}
+
+func ReadMIBL(reader io.Reader) (MIBL, error) {
+	mibl := bytes.NewBuffer(make(MIBL, 0))
+	if _, err := io.Copy(mibl, reader); err != nil {
+		return nil, errors.New("Error reading mibl: " + err.Error())
+	}
+	return mibl.Bytes(), nil
+}
+
+// GetMips reverses the swizzle and the minimum size padding of every mip, the surfaces are laid out the same way
+// dds.LoadDDS reads them
+func (mibl *MIBL) GetMips() ([][]byte, error) {
+	footer, err := mibl.GetFooter()
+	if err != nil {
+		return nil, err
+	}
+	if footer.Magic != MIBL_MAGIC {
+		return nil, errors.New("Invalid MIBL magic")
+	}
+	if footer.Version != MIBL_VERSION {
+		return nil, errors.New("Unsupported MIBL version: " + fmt.Sprint(footer.Version))
+	}
+	if footer.Depth > 1 || footer.ViewDimension != MIBL_VIEW_DIMENSION_2D {
+		return nil, errors.New("Unsupported MIBL dimension, only 2D textures are supported")
+	}
+	if footer.Width == 0 || footer.Height == 0 || footer.MipCount == 0 {
+		return nil, errors.New("Invalid MIBL size: " + fmt.Sprintf("%dx%d, %d mips", footer.Width, footer.Height, footer.MipCount))
+	}
+	format, found := MIBLFormatToDXGIFormat[footer.Format]
+	if !found {
+		return nil, errors.New("Unsupported MIBL format: " + fmt.Sprint(footer.Format))
+	}
+
+	formatInfo := dds.DXGI_FORMAT_INFO_MAP[format]
+	bytesPerBlock := formatInfo.BitsPerPixel * formatInfo.BlockSideLen * formatInfo.BlockSideLen / 8
+	mips := make([][]byte, footer.MipCount)
+
+	curMipWidth := footer.Width
+	curMipHeight := footer.Height
+	curOffset := uint32(0)
+	for i := range mips {
+		adjustedWidth := utils.Align(max(curMipWidth, MIBL_MIN_WIDTH), formatInfo.BlockSideLen)
+		adjustedHeight := utils.Align(max(curMipHeight, MIBL_MIN_HEIGHT), formatInfo.BlockSideLen)
+		adjustedMipSize := furnace.GetSwizzledSize(adjustedWidth, adjustedHeight, format)
+		if int(curOffset+adjustedMipSize) > len(*mibl)-int(unsafe.Sizeof(footer)) {
+			return nil, errors.New("MIBL data too short for mip " + fmt.Sprint(i))
+		}
+		adjustedMipData, err := furnace.GetDeswizzled((*mibl)[curOffset:curOffset+adjustedMipSize], adjustedWidth, adjustedHeight,
+			format)
+		if err != nil {
+			return nil, errors.New("Error reading mip " + fmt.Sprint(i) + ": " + err.Error())
+		}
+		curOffset += adjustedMipSize
+
+		widthBlocks := utils.Align(max(curMipWidth, 1), formatInfo.BlockSideLen) / formatInfo.BlockSideLen
+		heightBlocks := utils.Align(max(curMipHeight, 1), formatInfo.BlockSideLen) / formatInfo.BlockSideLen
+		rowSize := widthBlocks * bytesPerBlock
+		adjustedRowSize := adjustedWidth / formatInfo.BlockSideLen * bytesPerBlock
+		mips[i] = make([]byte, rowSize*heightBlocks)
+		for row := uint32(0); row < heightBlocks; row++ {
+			copy(mips[i][row*rowSize:(row+1)*rowSize], adjustedMipData[row*adjustedRowSize:])
+		}
+
+		curMipWidth /= 2
+		curMipHeight /= 2
+	}
+
+	return mips, nil
+}
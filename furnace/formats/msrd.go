@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
+	"strings"
 
 	"github.com/3096/furnace/furnace"
 	"github.com/3096/furnace/utils"
@@ -13,6 +15,19 @@ import (
 
 const MSRD_MAGIC uint32 = 'M'<<24 | 'S'<<16 | 'R'<<8 | 'D'
 
+// MSRD_VERSION and MSRD_META_TAG are the ones of the only wismt seen, a Xenoblade 2 file, and the metadata layout read
+// here is that file's. Other games may use other layouts, none is known without sample files.
+const MSRD_VERSION uint32 = 10001
+const MSRD_META_TAG uint32 = 0x1001
+
+func checkMSRDVersion(version uint32) error {
+	if version != MSRD_VERSION {
+		return errors.New("Unsupported msrd version " + fmt.Sprint(version) + ", only version " + fmt.Sprint(MSRD_VERSION) +
+			" is supported, the one seen in a Xenoblade 2 file")
+	}
+	return nil
+}
+
 type MSRDHeader struct {
 	Magic          uint32
 	Version        uint32
@@ -23,7 +38,8 @@ type MSRDHeader struct {
 type MSRDMetaData []byte
 
 type MSRDMetaDataHeader struct {
-	Tag      uint32
+	Tag uint32
+	// not checked: only 0x3F has been seen so far, the tables are bounds checked instead
 	Revision uint32
 
 	DataItemsCount       uint32
@@ -31,7 +47,16 @@ type MSRDMetaDataHeader struct {
 	FileCount            uint32
 	FileTableOffset      uint32
 
-	Unk1 [0x1C]byte
+	// data items of the model, the shader bundle and the texture cache
+	ModelDataItemIndex        uint32
+	ShaderBundleDataItemIndex uint32
+	TextureCacheDataItemIndex uint32
+	// file holding the texture cache, and file holding the split mips
+	TextureCacheFileIndex uint32
+	MipsFileIndex         uint32
+
+	TextureDataItemsStart uint32
+	TextureDataItemsCount uint32
 
 	TextureIdsCount   uint32
 	TextureIdsOffset  uint32
@@ -43,7 +68,8 @@ type MSRDDataItem struct {
 	Size             uint32
 	FileIndexPlusOne uint16
 	Type             MSRDDataItemType
-	Unk              [8]byte
+	// always 0 in the files seen so far
+	Reserved [2]uint32
 }
 
 type MSRDDataItemType uint16
@@ -55,6 +81,23 @@ const (
 	MSRD_DATA_ITEM_TYPE_TEXTURE      MSRDDataItemType = 3
 )
 
+var MSRDDataItemTypeNames = map[MSRDDataItemType]string{
+	MSRD_DATA_ITEM_TYPE_MODEL:        "model",
+	MSRD_DATA_ITEM_TYPE_SHADERBUNDLE: "shaderbundle",
+	MSRD_DATA_ITEM_TYPE_TEXTURECACHE: "texturecache",
+	MSRD_DATA_ITEM_TYPE_TEXTURE:      "texture",
+}
+
+func (dataItemType MSRDDataItemType) String() string {
+	if name, found := MSRDDataItemTypeNames[dataItemType]; found {
+		return name
+	}
+	return "unknown(" + fmt.Sprint(uint16(dataItemType)) + ")"
+}
+
+// data items are laid out on this alignment inside their file
+const MSRD_DATA_ITEM_ALIGN uint32 = 0x1000
+
 type MSRDFileItem struct {
 	CompressedSize   uint32
 	UncompressedSize uint32
@@ -63,6 +106,7 @@ type MSRDFileItem struct {
 
 const MSRD_FILE_ALIGN uint32 = 0x10
 
+// where the files seen so far have the texture cache and the split mips, the metadata header says where they are
 const MSRD_FILE_INDEX_0 = 0
 const MSRD_FILE_INDEX_MIPS = 1
 const MSRD_FILE_INDEX_TEXTURE_START = 2
@@ -76,8 +120,33 @@ type MSRDTextureInfoHeader struct {
 	TextureNamesOffset uint32
 }
 
+// MSRDTextureUsage tells the game what a texture is for, textures named *_SHY and *_MTL are both temp. The values
+// are the ones seen in the one wismt of the test data, there are likely more.
+type MSRDTextureUsage uint32
+
+const (
+	MSRD_TEXTURE_USAGE_TEMP MSRDTextureUsage = 0x00100000
+	MSRD_TEXTURE_USAGE_NRM  MSRDTextureUsage = 0x00120000
+	MSRD_TEXTURE_USAGE_COL  MSRDTextureUsage = 0x00200000
+	MSRD_TEXTURE_USAGE_ALP  MSRDTextureUsage = 0x10500000
+)
+
+var MSRDTextureUsageNames = map[MSRDTextureUsage]string{
+	MSRD_TEXTURE_USAGE_TEMP: "temp",
+	MSRD_TEXTURE_USAGE_NRM:  "nrm",
+	MSRD_TEXTURE_USAGE_COL:  "col",
+	MSRD_TEXTURE_USAGE_ALP:  "alp",
+}
+
+func (usage MSRDTextureUsage) String() string {
+	if name, found := MSRDTextureUsageNames[usage]; found {
+		return name
+	}
+	return "unknown(" + fmt.Sprintf("0x%08X", uint32(usage)) + ")"
+}
+
 type MSRDTextureInfoItem struct {
-	Unk         [4]byte
+	Usage       MSRDTextureUsage
 	CacheSize   uint32
 	CacheOffset uint32
 	NameOffset  uint32
@@ -87,11 +156,15 @@ type MSRD struct {
 	Header              MSRDHeader
 	MetaData            MSRDMetaData
 	CompressedFiles     []XBC1
+	FileItems           []MSRDFileItem
 	MetaHeader          MSRDMetaDataHeader
 	DataItems           []MSRDDataItem
 	TextureIdToIndexMap map[MSRDTextureId]int
 	TextureInfoHeader   MSRDTextureInfoHeader
 	TextureInfoItems    []MSRDTextureInfoItem
+	TextureNames        []string
+	// level of the files compressed again when the msrd is edited
+	CompressionLevel XBC1CompressionLevel
 }
 
 func ReadMSRD(reader io.ReadSeeker) (MSRD, error) {
@@ -102,109 +175,362 @@ func ReadMSRD(reader io.ReadSeeker) (MSRD, error) {
 	if header.Magic != MSRD_MAGIC {
 		return MSRD{}, errors.New("Invalid msrd header")
 	}
+	if err := checkMSRDVersion(header.Version); err != nil {
+		return MSRD{}, err
+	}
+	fileSize, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return MSRD{}, errors.New("Error getting msrd size: " + err.Error())
+	}
+	if int64(header.MetaDataOffset)+int64(header.MetaDataSize) > fileSize {
+		return MSRD{}, errors.New("Invalid msrd metadata: it goes past the end of the file")
+	}
 
 	reader.Seek(int64(header.MetaDataOffset), io.SeekStart)
 	metaData := make(MSRDMetaData, header.MetaDataSize)
-	if _, err := reader.Read(metaData); err != nil {
+	if _, err := io.ReadFull(reader, metaData); err != nil {
 		return MSRD{}, errors.New("Error reading msrd metadata: " + err.Error())
 	}
 
-	metaHeader := MSRDMetaDataHeader{}
-	metaDataBuffer := bytes.NewBuffer(metaData)
-	if err := binary.Read(metaDataBuffer, furnace.TargetByteOrder, &metaHeader); err != nil {
-		return MSRD{}, errors.New("Error reading msrd meta header: " + err.Error())
+	tables, err := ReadMSRDMetaData(metaData)
+	if err != nil {
+		return MSRD{}, err
 	}
 
-	reader.Seek(int64(header.MetaDataOffset+metaHeader.DataItemsTableOffset), io.SeekStart)
-	dataItems := make([]MSRDDataItem, metaHeader.DataItemsCount)
-	if err := binary.Read(reader, furnace.TargetByteOrder, &dataItems); err != nil {
-		return MSRD{}, errors.New("Error reading msrd data items: " + err.Error())
+	compressedFiles := make([]XBC1, len(tables.FileItems))
+	for i, fileItem := range tables.FileItems {
+		if int64(fileItem.Offset)+int64(fileItem.CompressedSize) > fileSize {
+			return MSRD{}, errors.New("Invalid msrd file " + fmt.Sprint(i) + ": it goes past the end of the file")
+		}
+		compressedFiles[i] = make(XBC1, fileItem.CompressedSize)
+		reader.Seek(int64(fileItem.Offset), io.SeekStart)
+		if _, err := io.ReadFull(reader, compressedFiles[i]); err != nil {
+			return MSRD{}, errors.New("Error reading msrd file " + fmt.Sprint(i) + ": " + err.Error())
+		}
 	}
 
-	reader.Seek(int64(header.MetaDataOffset+metaHeader.FileTableOffset), io.SeekStart)
-	fileItems := make([]MSRDFileItem, metaHeader.FileCount)
-	if err := binary.Read(reader, furnace.TargetByteOrder, &fileItems); err != nil {
-		return MSRD{}, errors.New("Error reading msrd file items: " + err.Error())
+	textureIdToIndexMap := make(map[MSRDTextureId]int)
+	for i, id := range tables.TextureIds {
+		textureIdToIndexMap[id] = i
 	}
 
-	compressedFiles := (make([]XBC1, metaHeader.FileCount))
-	for i := range fileItems {
-		compressedFiles[i] = make(XBC1, fileItems[i].CompressedSize)
-		reader.Seek(int64(fileItems[i].Offset), io.SeekStart)
-		if _, err := reader.Read(compressedFiles[i]); err != nil {
-			return MSRD{}, errors.New("Error reading msrd file " + fmt.Sprint(i) + ": " + err.Error())
+	return MSRD{
+		Header:              header,
+		MetaData:            metaData,
+		MetaHeader:          tables.MetaHeader,
+		DataItems:           tables.DataItems,
+		CompressedFiles:     compressedFiles,
+		FileItems:           tables.FileItems,
+		TextureIdToIndexMap: textureIdToIndexMap,
+		TextureInfoHeader:   tables.TextureInfoHeader,
+		TextureInfoItems:    tables.TextureInfoItems,
+		TextureNames:        tables.TextureNames,
+	}, nil
+}
+
+// MSRDMetaDataTables are the tables of the metadata as they're laid out in it, the wimdo embeds a copy of them
+type MSRDMetaDataTables struct {
+	MetaHeader        MSRDMetaDataHeader
+	DataItems         []MSRDDataItem
+	FileItems         []MSRDFileItem
+	TextureIds        []MSRDTextureId
+	TextureInfoHeader MSRDTextureInfoHeader
+	TextureInfoItems  []MSRDTextureInfoItem
+	TextureNames      []string
+}
+
+// ReadMSRDMetaData parses the tables of the metadata, every table has to fit in it
+func ReadMSRDMetaData(metaData MSRDMetaData) (MSRDMetaDataTables, error) {
+	var tables MSRDMetaDataTables
+	if err := readStructAt(metaData, 0, &tables.MetaHeader); err != nil {
+		return MSRDMetaDataTables{}, errors.New("Error reading msrd meta header: " + err.Error())
+	}
+	metaHeader := &tables.MetaHeader
+	if err := checkMSRDMetaTag(metaHeader.Tag); err != nil {
+		return MSRDMetaDataTables{}, err
+	}
+
+	// tables outside of the metadata mean the layout isn't the one expected, better to stop here than misread them
+	for _, table := range []struct {
+		name     string
+		offset   uint32
+		count    uint32
+		itemSize int
+	}{
+		{"data items", metaHeader.DataItemsTableOffset, metaHeader.DataItemsCount, binary.Size(MSRDDataItem{})},
+		{"file items", metaHeader.FileTableOffset, metaHeader.FileCount, binary.Size(MSRDFileItem{})},
+		{"texture ids", metaHeader.TextureIdsOffset, metaHeader.TextureIdsCount, binary.Size(MSRDTextureId(0))},
+		{"texture info header", metaHeader.TextureInfoOffset, 1, binary.Size(MSRDTextureInfoHeader{})},
+	} {
+		if err := checkMSRDTable(table.name, table.offset, table.count, table.itemSize, metaData); err != nil {
+			return MSRDMetaDataTables{}, err
 		}
 	}
 
-	reader.Seek(int64(header.MetaDataOffset+metaHeader.TextureIdsOffset), io.SeekStart)
-	textureIds := make([]MSRDTextureId, metaHeader.TextureIdsCount)
-	if err := binary.Read(reader, furnace.TargetByteOrder, &textureIds); err != nil {
-		return MSRD{}, errors.New("Error reading msrd texture ids: " + err.Error())
+	tables.DataItems = make([]MSRDDataItem, metaHeader.DataItemsCount)
+	if err := readStructAt(metaData, metaHeader.DataItemsTableOffset, &tables.DataItems); err != nil {
+		return MSRDMetaDataTables{}, errors.New("Error reading msrd data items: " + err.Error())
+	}
+	tables.FileItems = make([]MSRDFileItem, metaHeader.FileCount)
+	if err := readStructAt(metaData, metaHeader.FileTableOffset, &tables.FileItems); err != nil {
+		return MSRDMetaDataTables{}, errors.New("Error reading msrd file items: " + err.Error())
+	}
+	tables.TextureIds = make([]MSRDTextureId, metaHeader.TextureIdsCount)
+	if err := readStructAt(metaData, metaHeader.TextureIdsOffset, &tables.TextureIds); err != nil {
+		return MSRDMetaDataTables{}, errors.New("Error reading msrd texture ids: " + err.Error())
+	}
+	if err := readStructAt(metaData, metaHeader.TextureInfoOffset, &tables.TextureInfoHeader); err != nil {
+		return MSRDMetaDataTables{}, errors.New("Error reading msrd texture info header: " + err.Error())
 	}
 
-	textureIdToIndexMap := make(map[MSRDTextureId]int)
-	for i, id := range textureIds {
-		textureIdToIndexMap[id] = i
+	textureInfoItemsOffset := metaHeader.TextureInfoOffset + uint32(binary.Size(tables.TextureInfoHeader))
+	err := checkMSRDTable("texture info items", textureInfoItemsOffset, tables.TextureInfoHeader.TextureCount,
+		binary.Size(MSRDTextureInfoItem{}), metaData)
+	if err != nil {
+		return MSRDMetaDataTables{}, err
+	}
+	tables.TextureInfoItems = make([]MSRDTextureInfoItem, tables.TextureInfoHeader.TextureCount)
+	if err := readStructAt(metaData, textureInfoItemsOffset, &tables.TextureInfoItems); err != nil {
+		return MSRDMetaDataTables{}, errors.New("Error reading msrd texture info items: " + err.Error())
 	}
 
-	textureInfoHeader := MSRDTextureInfoHeader{}
-	reader.Seek(int64(header.MetaDataOffset+metaHeader.TextureInfoOffset), io.SeekStart)
-	if err := binary.Read(reader, furnace.TargetByteOrder, &textureInfoHeader); err != nil {
-		return MSRD{}, errors.New("Error reading msrd texture info header: " + err.Error())
+	tables.TextureNames = make([]string, len(tables.TextureInfoItems))
+	for i, textureInfoItem := range tables.TextureInfoItems {
+		nameOffset := int(metaHeader.TextureInfoOffset + textureInfoItem.NameOffset)
+		if nameOffset >= len(metaData) {
+			return MSRDMetaDataTables{}, errors.New("Invalid msrd texture name offset for texture " + fmt.Sprint(i))
+		}
+		nameLength := bytes.IndexByte(metaData[nameOffset:], 0)
+		if nameLength < 0 {
+			return MSRDMetaDataTables{}, errors.New("Unterminated msrd texture name for texture " + fmt.Sprint(i))
+		}
+		tables.TextureNames[i] = string(metaData[nameOffset : nameOffset+nameLength])
 	}
 
-	textureInfoItems := make([]MSRDTextureInfoItem, textureInfoHeader.TextureCount)
-	if err := binary.Read(reader, furnace.TargetByteOrder, &textureInfoItems); err != nil {
-		return MSRD{}, errors.New("Error reading msrd texture info items: " + err.Error())
+	return tables, nil
+}
+
+// Write puts the tables back over the metadata they were read from. Tables can't change size this way, names aren't
+// written back.
+func (tables *MSRDMetaDataTables) Write(metaData MSRDMetaData) error {
+	layout, err := tables.getLayout()
+	if err != nil {
+		return err
+	}
+	for _, table := range layout {
+		if err := writeStructAt(metaData, table.offset, table.value); err != nil {
+			return errors.New("Error writing msrd metadata: " + err.Error())
+		}
 	}
+	return nil
+}
 
-	return MSRD{
-		Header:              header,
-		MetaData:            metaData,
-		MetaHeader:          metaHeader,
-		DataItems:           dataItems,
-		CompressedFiles:     compressedFiles,
-		TextureIdToIndexMap: textureIdToIndexMap,
-		TextureInfoHeader:   textureInfoHeader,
-		TextureInfoItems:    textureInfoItems,
+type msrdMetaDataTable struct {
+	offset uint32
+	value  interface{}
+}
+
+// getLayout gives the tables with the offsets the headers give them, the tables have to match their counts
+func (tables *MSRDMetaDataTables) getLayout() ([]msrdMetaDataTable, error) {
+	metaHeader := &tables.MetaHeader
+	if len(tables.DataItems) != int(metaHeader.DataItemsCount) || len(tables.FileItems) != int(metaHeader.FileCount) ||
+		len(tables.TextureIds) != int(metaHeader.TextureIdsCount) ||
+		len(tables.TextureInfoItems) != int(tables.TextureInfoHeader.TextureCount) {
+		return nil, errors.New("Msrd table sizes don't match their counts")
+	}
+	return []msrdMetaDataTable{
+		{0, metaHeader},
+		{metaHeader.DataItemsTableOffset, tables.DataItems},
+		{metaHeader.FileTableOffset, tables.FileItems},
+		{metaHeader.TextureIdsOffset, tables.TextureIds},
+		{metaHeader.TextureInfoOffset, &tables.TextureInfoHeader},
+		{metaHeader.TextureInfoOffset + uint32(binary.Size(tables.TextureInfoHeader)), tables.TextureInfoItems},
 	}, nil
 }
 
-func WriteMSRD(writer io.WriteSeeker, msrd MSRD) error {
-	// I wrote this assuming the data size of metadata won't change...
-	// if the need arises, however, it might need some overhaul
+// CompareMSRDMetaData tells which part of two metadata differs first, tables before raw bytes
+func CompareMSRDMetaData(expected, actual MSRDMetaData) error {
+	expectedTables, err := ReadMSRDMetaData(expected)
+	if err != nil {
+		return errors.New("Error reading expected metadata: " + err.Error())
+	}
+	actualTables, err := ReadMSRDMetaData(actual)
+	if err != nil {
+		return errors.New("Error reading metadata: " + err.Error())
+	}
+	for _, table := range []struct {
+		name             string
+		expected, actual interface{}
+	}{
+		{"meta header", expectedTables.MetaHeader, actualTables.MetaHeader},
+		{"data items", expectedTables.DataItems, actualTables.DataItems},
+		{"file items", expectedTables.FileItems, actualTables.FileItems},
+		{"texture ids", expectedTables.TextureIds, actualTables.TextureIds},
+		{"texture info header", expectedTables.TextureInfoHeader, actualTables.TextureInfoHeader},
+		{"texture info items", expectedTables.TextureInfoItems, actualTables.TextureInfoItems},
+		{"texture names", expectedTables.TextureNames, actualTables.TextureNames},
+	} {
+		if !reflect.DeepEqual(table.expected, table.actual) {
+			return errors.New("the " + table.name + " differ")
+		}
+	}
+	if len(expected) != len(actual) {
+		return errors.New("sizes differ: " + fmt.Sprint(len(expected)) + " and " + fmt.Sprint(len(actual)) + " bytes")
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			return errors.New("bytes differ at offset " + fmt.Sprintf("0x%X", i))
+		}
+	}
+	return nil
+}
 
-	if err := binary.Write(utils.NewInPlaceWriter(msrd.MetaData, 0), furnace.TargetByteOrder, &msrd.MetaHeader); err != nil {
-		return errors.New("Error writing msrd meta header: " + err.Error())
+func checkMSRDMetaTag(tag uint32) error {
+	if tag == MSRD_META_TAG {
+		return nil
+	}
+	return errors.New("Unsupported msrd metadata tag " + fmt.Sprintf("0x%X", tag) + ", only " +
+		fmt.Sprintf("0x%X", MSRD_META_TAG) + " is supported, the one seen in a Xenoblade 2 file")
+}
+
+func checkMSRDTable(name string, offset, count uint32, itemSize int, metaData MSRDMetaData) error {
+	if count > 0 && offset < uint32(binary.Size(MSRDMetaDataHeader{})) {
+		return errors.New("Invalid msrd " + name + " offset " + fmt.Sprint(offset) + ": it overlaps the meta header")
 	}
+	if uint64(offset)+uint64(count)*uint64(itemSize) > uint64(len(metaData)) {
+		return errors.New("Invalid msrd " + name + ": " + fmt.Sprint(count) + " items at offset " + fmt.Sprint(offset) +
+			" go past the " + fmt.Sprint(len(metaData)) + " bytes of metadata")
+	}
+	return nil
+}
 
-	if err := binary.Write(utils.NewInPlaceWriter(msrd.MetaData, int(msrd.MetaHeader.DataItemsTableOffset)), furnace.TargetByteOrder, &msrd.DataItems); err != nil {
+// UpdateMetaData lays out the metadata again from the parsed tables, so they can grow or shrink. The table offsets
+// in MetaHeader, the texture name offsets, Header.MetaDataSize and the file table are updated along the way. Bytes
+// between the meta header and the first table are carried over as they are. Nothing is known to point after the
+// texture names, so metadata with anything but padding there is refused instead of losing it.
+func (msrd *MSRD) UpdateMetaData() error {
+	metaHeaderSize := binary.Size(&msrd.MetaHeader)
+	if len(msrd.TextureNames) != len(msrd.TextureInfoItems) {
+		return errors.New("Texture names don't match texture info items")
+	}
+	if err := checkMSRDMetaDataTail(msrd.MetaData); err != nil {
+		return err
+	}
+	textureIds := make([]MSRDTextureId, len(msrd.TextureIdToIndexMap))
+	textureIdIsSet := make([]bool, len(msrd.TextureIdToIndexMap))
+	for id, index := range msrd.TextureIdToIndexMap {
+		if index < 0 || index >= len(textureIds) || textureIdIsSet[index] {
+			return errors.New("Invalid texture index in texture id map: " + fmt.Sprint(index))
+		}
+		textureIds[index] = id
+		textureIdIsSet[index] = true
+	}
+
+	metaDataBuffer := bytes.NewBuffer(make([]byte, metaHeaderSize))
+	if int(msrd.MetaHeader.DataItemsTableOffset) > metaHeaderSize && int(msrd.MetaHeader.DataItemsTableOffset) <= len(msrd.MetaData) {
+		metaDataBuffer.Write(msrd.MetaData[metaHeaderSize:msrd.MetaHeader.DataItemsTableOffset])
+	}
+
+	msrd.MetaHeader.DataItemsCount = uint32(len(msrd.DataItems))
+	msrd.MetaHeader.DataItemsTableOffset = uint32(metaDataBuffer.Len())
+	if err := binary.Write(metaDataBuffer, furnace.TargetByteOrder, &msrd.DataItems); err != nil {
 		return errors.New("Error writing msrd data items: " + err.Error())
 	}
 
-	fileItemsWriter := utils.NewInPlaceWriter(msrd.MetaData, int(msrd.MetaHeader.FileTableOffset))
-	curFileOffset := msrd.Header.MetaDataOffset + msrd.Header.MetaDataSize
+	// offsets of the files depend on the final metadata size, the file table is filled in at the end
+	msrd.MetaHeader.FileCount = uint32(len(msrd.CompressedFiles))
+	msrd.MetaHeader.FileTableOffset = uint32(metaDataBuffer.Len())
+	metaDataBuffer.Write(make([]byte, binary.Size(MSRDFileItem{})*len(msrd.CompressedFiles)))
+
+	msrd.MetaHeader.TextureIdsCount = uint32(len(textureIds))
+	msrd.MetaHeader.TextureIdsOffset = uint32(metaDataBuffer.Len())
+	if err := binary.Write(metaDataBuffer, furnace.TargetByteOrder, &textureIds); err != nil {
+		return errors.New("Error writing msrd texture ids: " + err.Error())
+	}
+
+	msrd.MetaHeader.TextureInfoOffset = uint32(metaDataBuffer.Len())
+	msrd.TextureInfoHeader.TextureCount = uint32(len(msrd.TextureInfoItems))
+	msrd.TextureInfoHeader.TextureNamesOffset = uint32(binary.Size(&msrd.TextureInfoHeader) + binary.Size(&msrd.TextureInfoItems))
+	curNameOffset := msrd.TextureInfoHeader.TextureNamesOffset
+	for i := range msrd.TextureInfoItems {
+		msrd.TextureInfoItems[i].NameOffset = curNameOffset
+		curNameOffset += uint32(len(msrd.TextureNames[i]) + 1)
+	}
+	if err := binary.Write(metaDataBuffer, furnace.TargetByteOrder, &msrd.TextureInfoHeader); err != nil {
+		return errors.New("Error writing msrd texture info header: " + err.Error())
+	}
+	if err := binary.Write(metaDataBuffer, furnace.TargetByteOrder, &msrd.TextureInfoItems); err != nil {
+		return errors.New("Error writing msrd texture info items: " + err.Error())
+	}
+	for _, textureName := range msrd.TextureNames {
+		metaDataBuffer.WriteString(textureName)
+		metaDataBuffer.WriteByte(0)
+	}
+
+	metaData := metaDataBuffer.Bytes()
+	metaDataEnd := utils.Align(msrd.Header.MetaDataOffset+uint32(len(metaData)), MSRD_FILE_ALIGN)
+	metaData = append(metaData, make([]byte, metaDataEnd-msrd.Header.MetaDataOffset-uint32(len(metaData)))...)
+	msrd.Header.MetaDataSize = uint32(len(metaData))
+
+	if err := binary.Write(utils.NewInPlaceWriter(metaData, 0), furnace.TargetByteOrder, &msrd.MetaHeader); err != nil {
+		return errors.New("Error writing msrd meta header: " + err.Error())
+	}
+
+	msrd.FileItems = make([]MSRDFileItem, len(msrd.CompressedFiles))
+	curFileOffset := metaDataEnd
 	for i := range msrd.CompressedFiles {
 		xbc1Header, err := ReadXBC1Header(bytes.NewReader(msrd.CompressedFiles[i]))
 		if err != nil {
 			return errors.New("Error reading xbc1 header: " + err.Error())
 		}
-		if err := binary.Write(fileItemsWriter, furnace.TargetByteOrder, &MSRDFileItem{
+		msrd.FileItems[i] = MSRDFileItem{
 			CompressedSize:   uint32(len(msrd.CompressedFiles[i])),
 			UncompressedSize: xbc1Header.UncompressedSize,
 			Offset:           curFileOffset,
-		}); err != nil {
-			return errors.New("Error writing msrd file items: " + err.Error())
 		}
 		curFileOffset += uint32(len(msrd.CompressedFiles[i]))
 	}
+	if err := binary.Write(utils.NewInPlaceWriter(metaData, int(msrd.MetaHeader.FileTableOffset)), furnace.TargetByteOrder, &msrd.FileItems); err != nil {
+		return errors.New("Error writing msrd file items: " + err.Error())
+	}
 
-	textureInfoWriter := utils.NewInPlaceWriter(msrd.MetaData, int(msrd.MetaHeader.TextureInfoOffset))
-	if err := binary.Write(textureInfoWriter, furnace.TargetByteOrder, &msrd.TextureInfoHeader); err != nil {
-		return errors.New("Error writing msrd texture info header: " + err.Error())
+	msrd.MetaData = metaData
+	return nil
+}
+
+// checkMSRDMetaDataTail makes sure only zeros follow the last table of the metadata. Metadata being built from
+// scratch, like pack does, only has the bytes after the meta header and no tables to check.
+func checkMSRDMetaDataTail(metaData MSRDMetaData) error {
+	tables, err := ReadMSRDMetaData(metaData)
+	if err != nil {
+		return nil
 	}
-	if err := binary.Write(textureInfoWriter, furnace.TargetByteOrder, &msrd.TextureInfoItems); err != nil {
-		return errors.New("Error writing msrd texture info items: " + err.Error())
+	metaHeader := &tables.MetaHeader
+	textureInfoItemsOffset := metaHeader.TextureInfoOffset + uint32(binary.Size(tables.TextureInfoHeader))
+	tablesEnd := uint32(binary.Size(metaHeader))
+	for _, end := range []uint32{
+		metaHeader.DataItemsTableOffset + uint32(binary.Size(tables.DataItems)),
+		metaHeader.FileTableOffset + uint32(binary.Size(tables.FileItems)),
+		metaHeader.TextureIdsOffset + uint32(binary.Size(tables.TextureIds)),
+		textureInfoItemsOffset + uint32(binary.Size(tables.TextureInfoItems)),
+	} {
+		tablesEnd = max(tablesEnd, end)
+	}
+	for i, item := range tables.TextureInfoItems {
+		tablesEnd = max(tablesEnd, metaHeader.TextureInfoOffset+item.NameOffset+uint32(len(tables.TextureNames[i]))+1)
+	}
+	for i := int(tablesEnd); i < len(metaData); i++ {
+		if metaData[i] != 0 {
+			return errors.New("Unknown data at " + fmt.Sprintf("0x%X", i) + " after the tables of the msrd metadata, " +
+				"it would be lost when laying out the metadata again")
+		}
+	}
+	return nil
+}
+
+func WriteMSRD(writer io.WriteSeeker, msrd MSRD) error {
+	if err := msrd.UpdateMetaData(); err != nil {
+		return err
 	}
 
 	if err := binary.Write(writer, furnace.TargetByteOrder, &msrd.Header); err != nil {
@@ -231,20 +557,172 @@ func (msrd *MSRD) GetDataItemsByType(dataItemType MSRDDataItemType) []MSRDDataIt
 	return result
 }
 
+func (msrd *MSRD) GetTextureIdsByName(name string) []MSRDTextureId {
+	var result []MSRDTextureId
+	for i, textureName := range msrd.TextureNames {
+		if textureName == name {
+			result = append(result, MSRDTextureId(i))
+		}
+	}
+	return result
+}
+
+// GetDataItemFileIndex returns the file holding the data of an item. The split mips are in MipsFileIndex and the
+// texture cache in TextureCacheFileIndex, as the metadata header says. FileIndexPlusOne of a texture item points to
+// its high-res file instead; for the other items it's their file, 0 meaning file 0 like in every file seen so far.
+func (msrd *MSRD) GetDataItemFileIndex(index int) int {
+	if index >= int(msrd.MetaHeader.TextureDataItemsStart) &&
+		index-int(msrd.MetaHeader.TextureDataItemsStart) < int(msrd.MetaHeader.TextureDataItemsCount) {
+		return int(msrd.MetaHeader.MipsFileIndex)
+	}
+	if index == int(msrd.MetaHeader.TextureCacheDataItemIndex) {
+		return int(msrd.MetaHeader.TextureCacheFileIndex)
+	}
+	if msrd.DataItems[index].FileIndexPlusOne != 0 {
+		return int(msrd.DataItems[index].FileIndexPlusOne) - 1
+	}
+	return MSRD_FILE_INDEX_0
+}
+
+// GetHeaderDataItemIndex returns the item the metadata header gives for the model, shader bundle or texture cache
+func (msrd *MSRD) GetHeaderDataItemIndex(dataItemType MSRDDataItemType) (int, error) {
+	var index uint32
+	switch dataItemType {
+	case MSRD_DATA_ITEM_TYPE_MODEL:
+		index = msrd.MetaHeader.ModelDataItemIndex
+	case MSRD_DATA_ITEM_TYPE_SHADERBUNDLE:
+		index = msrd.MetaHeader.ShaderBundleDataItemIndex
+	case MSRD_DATA_ITEM_TYPE_TEXTURECACHE:
+		index = msrd.MetaHeader.TextureCacheDataItemIndex
+	default:
+		return 0, errors.New("The msrd metadata header has no " + dataItemType.String() + " data item")
+	}
+	if int(index) >= len(msrd.DataItems) {
+		return 0, errors.New("The " + dataItemType.String() + " data item " + fmt.Sprint(index) + " is out of range")
+	}
+	if msrd.DataItems[index].Type != dataItemType {
+		return 0, errors.New("The " + dataItemType.String() + " data item " + fmt.Sprint(index) + " is a " +
+			msrd.DataItems[index].Type.String() + " item")
+	}
+	return int(index), nil
+}
+
+// getTextureDataItemsEnd checks the texture data items of the metadata header and returns where they end
+func (msrd *MSRD) getTextureDataItemsEnd() (int, error) {
+	end := uint64(msrd.MetaHeader.TextureDataItemsStart) + uint64(msrd.MetaHeader.TextureDataItemsCount)
+	if end > uint64(len(msrd.DataItems)) {
+		return 0, errors.New("Texture data items " + fmt.Sprint(msrd.MetaHeader.TextureDataItemsStart) + " to " +
+			fmt.Sprint(end) + " are out of range")
+	}
+	return int(end), nil
+}
+
+func (msrd *MSRD) GetDataItemData(index int) ([]byte, error) {
+	if index < 0 || index >= len(msrd.DataItems) {
+		return nil, errors.New("Data item index " + fmt.Sprint(index) + " is out of range")
+	}
+	dataItem := msrd.DataItems[index]
+	fileIndex := msrd.GetDataItemFileIndex(index)
+	if fileIndex >= len(msrd.CompressedFiles) {
+		return nil, errors.New("Data item " + fmt.Sprint(index) + " is in file " + fmt.Sprint(fileIndex) + ", which doesn't exist")
+	}
+	_, fileContent, err := ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[fileIndex]))
+	if err != nil {
+		return nil, errors.New("Error extracting file " + fmt.Sprint(fileIndex) + ": " + err.Error())
+	}
+	if uint64(dataItem.Offset)+uint64(dataItem.Size) > uint64(len(fileContent)) {
+		return nil, errors.New("Data item " + fmt.Sprint(index) + " exceeds file " + fmt.Sprint(fileIndex))
+	}
+	return fileContent[dataItem.Offset : dataItem.Offset+dataItem.Size], nil
+}
+
+// SetDataItemData replaces the data of an item inside its file. Whatever follows it in the file moves to the next
+// MSRD_DATA_ITEM_ALIGN boundary after the new data, and the offsets of the items there are updated.
+func (msrd *MSRD) SetDataItemData(index int, data []byte) error {
+	if index < 0 || index >= len(msrd.DataItems) {
+		return errors.New("Data item index " + fmt.Sprint(index) + " is out of range")
+	}
+	dataItem := msrd.DataItems[index]
+	fileIndex := msrd.GetDataItemFileIndex(index)
+	if fileIndex >= len(msrd.CompressedFiles) {
+		return errors.New("Data item " + fmt.Sprint(index) + " is in file " + fmt.Sprint(fileIndex) + ", which doesn't exist")
+	}
+	xbc1Header, fileContent, err := ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[fileIndex]))
+	if err != nil {
+		return errors.New("Error extracting file " + fmt.Sprint(fileIndex) + ": " + err.Error())
+	}
+	oldEnd := dataItem.Offset + dataItem.Size
+	if oldEnd > uint32(len(fileContent)) {
+		return errors.New("Data item " + fmt.Sprint(index) + " exceeds file " + fmt.Sprint(fileIndex))
+	}
+	for i, otherDataItem := range msrd.DataItems {
+		if i != index && msrd.GetDataItemFileIndex(i) == fileIndex &&
+			otherDataItem.Offset < oldEnd && otherDataItem.Offset+otherDataItem.Size > dataItem.Offset {
+			return errors.New("Data item " + fmt.Sprint(index) + " overlaps data item " + fmt.Sprint(i))
+		}
+	}
+
+	fileBuffer := bytes.NewBuffer(make([]byte, 0, len(fileContent)))
+	fileBuffer.Write(fileContent[:dataItem.Offset])
+	fileBuffer.Write(data)
+	oldTailStart := utils.Align(oldEnd, MSRD_DATA_ITEM_ALIGN)
+	newTailStart := utils.Align(dataItem.Offset+uint32(len(data)), MSRD_DATA_ITEM_ALIGN)
+	for i, otherDataItem := range msrd.DataItems {
+		// an unaligned item right after this one stays right after it
+		if i != index && msrd.GetDataItemFileIndex(i) == fileIndex && otherDataItem.Offset >= oldEnd && otherDataItem.Offset < oldTailStart {
+			oldTailStart = oldEnd
+			newTailStart = dataItem.Offset + uint32(len(data))
+		}
+	}
+	if oldTailStart < uint32(len(fileContent)) {
+		fileBuffer.Write(make([]byte, newTailStart-uint32(fileBuffer.Len())))
+		fileBuffer.Write(fileContent[oldTailStart:])
+	}
+
+	for i := range msrd.DataItems {
+		if i != index && msrd.GetDataItemFileIndex(i) == fileIndex && msrd.DataItems[i].Offset >= oldEnd {
+			msrd.DataItems[i].Offset = msrd.DataItems[i].Offset - oldTailStart + newTailStart
+		}
+	}
+	msrd.DataItems[index].Size = uint32(len(data))
+
+	// keep the original file, and its hash, when nothing changed
+	if bytes.Equal(fileBuffer.Bytes(), fileContent) {
+		return nil
+	}
+	compressedFile, err := CompressToXBC1WithLevel(xbc1Header.CompressionType, msrd.CompressionLevel, xbc1Header.Name, fileBuffer.Bytes())
+	if err != nil {
+		return errors.New("Error writing file " + fmt.Sprint(fileIndex) + ": " + err.Error())
+	}
+	msrd.SetCompressedFileData(fileIndex, compressedFile)
+	return nil
+}
+
 func (msrd *MSRD) SetCompressedFileData(index int, data XBC1) {
 	msrd.CompressedFiles[index] = append([]byte(data), make([]byte, MSRD_FILE_ALIGN-uint32(len(data))%MSRD_FILE_ALIGN)...)
 }
 
+// GetSplitMips returns the split mips of the texture data items, read from the mips file
 func (msrd *MSRD) GetSplitMips() ([]MIBL, error) {
-	var mips []MIBL
-	_, jointMipsFile, err := ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[MSRD_FILE_INDEX_MIPS]))
+	texturesEnd, err := msrd.getTextureDataItemsEnd()
+	if err != nil {
+		return nil, err
+	}
+	mipsFileIndex := int(msrd.MetaHeader.MipsFileIndex)
+	if mipsFileIndex >= len(msrd.CompressedFiles) {
+		return nil, errors.New("Mips file " + fmt.Sprint(mipsFileIndex) + " doesn't exist")
+	}
+	_, jointMipsFile, err := ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[mipsFileIndex]))
 	if err != nil {
 		return nil, errors.New("Error extracting mips file: " + err.Error())
 	}
-	for _, dataItem := range msrd.DataItems {
-		if dataItem.Type == MSRD_DATA_ITEM_TYPE_TEXTURE {
-			mips = append(mips, MIBL(jointMipsFile[dataItem.Offset:dataItem.Offset+dataItem.Size]))
+	var mips []MIBL
+	for i := int(msrd.MetaHeader.TextureDataItemsStart); i < texturesEnd; i++ {
+		dataItem := msrd.DataItems[i]
+		if uint64(dataItem.Offset)+uint64(dataItem.Size) > uint64(len(jointMipsFile)) {
+			return nil, errors.New("Data item " + fmt.Sprint(i) + " exceeds the mips file")
 		}
+		mips = append(mips, MIBL(jointMipsFile[dataItem.Offset:dataItem.Offset+dataItem.Size]))
 	}
 	if len(mips) != int(msrd.MetaHeader.TextureIdsCount) {
 		return nil, errors.New("Invalid mips count")
@@ -252,10 +730,19 @@ func (msrd *MSRD) GetSplitMips() ([]MIBL, error) {
 	return mips, nil
 }
 
+// SetMips saves the split mips to the mips file, in the order of the texture data items
 func (msrd *MSRD) SetMips(splitMips []MIBL) error {
-	if len(splitMips) != int(msrd.MetaHeader.TextureIdsCount) {
+	texturesEnd, err := msrd.getTextureDataItemsEnd()
+	if err != nil {
+		return err
+	}
+	if len(splitMips) != int(msrd.MetaHeader.TextureIdsCount) || len(splitMips) != int(msrd.MetaHeader.TextureDataItemsCount) {
 		return errors.New("Invalid number of mips")
 	}
+	mipsFileIndex := int(msrd.MetaHeader.MipsFileIndex)
+	if mipsFileIndex >= len(msrd.CompressedFiles) {
+		return errors.New("Mips file " + fmt.Sprint(mipsFileIndex) + " doesn't exist")
+	}
 
 	jointMipsMSRDFileBuffer := bytes.NewBuffer(make([]byte, 0))
 	mipsOffsets := []uint32{0}
@@ -263,84 +750,307 @@ func (msrd *MSRD) SetMips(splitMips []MIBL) error {
 		jointMipsMSRDFileBuffer.Write(curMips)
 		mipsOffsets = append(mipsOffsets, mipsOffsets[i]+uint32(len(curMips)))
 	}
-	jointMipsMSRDFileHeader, err := ReadXBC1Header(bytes.NewReader(msrd.CompressedFiles[MSRD_FILE_INDEX_MIPS]))
+	jointMipsMSRDFileHeader, jointMipsMSRDFileContent, err := ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[mipsFileIndex]))
 	if err != nil {
-		return errors.New("Error reading mips header: " + err.Error())
+		return errors.New("Error extracting mips file: " + err.Error())
 	}
-	jointMipsMSRDFileData, err := CompressToXBC1(jointMipsMSRDFileHeader.Name, jointMipsMSRDFileBuffer.Bytes())
-	if err != nil {
-		return errors.New("Error writing mips file: " + err.Error())
+	// keep the original file, and its hash, when nothing changed
+	if !bytes.Equal(jointMipsMSRDFileBuffer.Bytes(), jointMipsMSRDFileContent) {
+		jointMipsMSRDFileData, err := CompressToXBC1WithLevel(jointMipsMSRDFileHeader.CompressionType, msrd.CompressionLevel, jointMipsMSRDFileHeader.Name, jointMipsMSRDFileBuffer.Bytes())
+		if err != nil {
+			return errors.New("Error writing mips file: " + err.Error())
+		}
+		msrd.SetCompressedFileData(mipsFileIndex, jointMipsMSRDFileData)
 	}
-	msrd.SetCompressedFileData(MSRD_FILE_INDEX_MIPS, jointMipsMSRDFileData)
 
-	for i, dataItem := range msrd.DataItems {
-		if dataItem.Type == MSRD_DATA_ITEM_TYPE_TEXTURE {
-			textureIndex := dataItem.FileIndexPlusOne - 1 - MSRD_FILE_INDEX_TEXTURE_START
-			msrd.DataItems[i].Size = uint32(len(splitMips[textureIndex]))
-			msrd.DataItems[i].Offset = mipsOffsets[textureIndex]
-		}
+	for i := int(msrd.MetaHeader.TextureDataItemsStart); i < texturesEnd; i++ {
+		textureIndex := i - int(msrd.MetaHeader.TextureDataItemsStart)
+		msrd.DataItems[i].Size = uint32(len(splitMips[textureIndex]))
+		msrd.DataItems[i].Offset = mipsOffsets[textureIndex]
 	}
 
 	return nil
 }
 
+// GetCachedTextures returns the cached MIBL of every texture, read from the texture cache data item
 func (msrd *MSRD) GetCachedTextures() ([]MIBL, error) {
-	var textures []MIBL
-
-	cachedTextureDataItems := msrd.GetDataItemsByType(MSRD_DATA_ITEM_TYPE_TEXTURECACHE)
-	if len(cachedTextureDataItems) != 1 {
-		return nil, errors.New("Invalid number of cached texture data items")
+	textureCacheIndex, err := msrd.GetHeaderDataItemIndex(MSRD_DATA_ITEM_TYPE_TEXTURECACHE)
+	if err != nil {
+		return nil, err
 	}
-	cachedTextureDataOffset := cachedTextureDataItems[0].Offset
-
-	_, file0Content, err := ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[MSRD_FILE_INDEX_0]))
+	textureCache, err := msrd.GetDataItemData(textureCacheIndex)
 	if err != nil {
-		return nil, errors.New("Error extracting file 0: " + err.Error())
+		return nil, err
 	}
 
-	for _, textureInfoItem := range msrd.TextureInfoItems {
-		textureCacheOffset := cachedTextureDataOffset + textureInfoItem.CacheOffset
-		textures = append(textures, MIBL(file0Content[textureCacheOffset:textureCacheOffset+textureInfoItem.CacheSize]))
+	var textures []MIBL
+	for i, textureInfoItem := range msrd.TextureInfoItems {
+		if uint64(textureInfoItem.CacheOffset)+uint64(textureInfoItem.CacheSize) > uint64(len(textureCache)) {
+			return nil, errors.New("Cached texture " + fmt.Sprint(i) + " exceeds the texture cache")
+		}
+		textures = append(textures, MIBL(textureCache[textureInfoItem.CacheOffset:textureInfoItem.CacheOffset+textureInfoItem.CacheSize]))
 	}
 
 	return textures, nil
 }
 
+// SetCachedTextures saves the cached MIBLs to the texture cache data item, wherever it is in its file
 func (msrd *MSRD) SetCachedTextures(textures []MIBL) error {
 	if len(textures) != int(msrd.TextureInfoHeader.TextureCount) {
 		return errors.New("Invalid number of textures")
 	}
-
-	file0XBC1Header, file0Content, err := ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[MSRD_FILE_INDEX_0]))
+	textureCacheIndex, err := msrd.GetHeaderDataItemIndex(MSRD_DATA_ITEM_TYPE_TEXTURECACHE)
 	if err != nil {
-		return errors.New("Error extracting file 0: " + err.Error())
+		return err
 	}
 
-	cachedTextureDataItems := msrd.GetDataItemsByType(MSRD_DATA_ITEM_TYPE_TEXTURECACHE)
-	if len(cachedTextureDataItems) != 1 {
-		return errors.New("Invalid number of cached texture data items")
-	}
-	cachedTextureDataOffset := cachedTextureDataItems[0].Offset
-	if int(cachedTextureDataOffset+cachedTextureDataItems[0].Size) != len(file0Content) {
-		// I'm assuming texture cache is at the end of file 0 cuz I'm feeling lazy
-		// if it's not, this should catch it
-		return errors.New("Additional data after texture cache detected in file 0, unsupported")
-	}
-
-	textureCacheWriter := utils.NewInPlaceWriter(file0Content, int(cachedTextureDataOffset))
+	textureCacheBuffer := bytes.NewBuffer(make([]byte, 0))
 	curTextureCacheOffset := uint32(0)
 	for i, curTexture := range textures {
 		msrd.TextureInfoItems[i].CacheOffset = curTextureCacheOffset
 		msrd.TextureInfoItems[i].CacheSize = uint32(len(curTexture))
-		textureCacheWriter.Write(curTexture)
+		textureCacheBuffer.Write(curTexture)
 		curTextureCacheOffset += uint32(len(curTexture))
 	}
 
-	file0Compressed, err := CompressToXBC1(file0XBC1Header.Name, file0Content)
-	if err != nil {
-		return errors.New("Error writing file 0: " + err.Error())
+	if err := msrd.SetDataItemData(textureCacheIndex, textureCacheBuffer.Bytes()); err != nil {
+		return errors.New("Error writing texture cache: " + err.Error())
 	}
-	msrd.SetCompressedFileData(MSRD_FILE_INDEX_0, file0Compressed)
+	return nil
+}
+
+// AddTextureEntry registers a new streamed texture: its id, texture info, name, high-res file and data item. The
+// high-res file is renamed after the index it ends up at. Its cached MIBL and split mips have to be saved with
+// SetCachedTextures and SetMips afterwards, in the order of the ids.
+func (msrd *MSRD) AddTextureEntry(name string, usage MSRDTextureUsage, highResFile XBC1) (MSRDTextureId, error) {
+	if len(msrd.TextureNames) != len(msrd.TextureInfoItems) {
+		return 0, errors.New("Texture names don't match texture info items")
+	}
+
+	textureId := MSRDTextureId(len(msrd.TextureInfoItems))
+	if err := msrd.addTextureStreamEntry(textureId, highResFile); err != nil {
+		return 0, err
+	}
+	msrd.TextureInfoItems = append(msrd.TextureInfoItems, MSRDTextureInfoItem{Usage: usage})
+	msrd.TextureNames = append(msrd.TextureNames, name)
+	msrd.TextureInfoHeader.TextureCount = uint32(len(msrd.TextureInfoItems))
+
+	return textureId, nil
+}
+
+// PromoteTextureEntry turns a texture that only has a cached MIBL into a streamed texture, by giving it a high-res
+// file and data item. Its split mips have to be appended with SetMips afterwards.
+func (msrd *MSRD) PromoteTextureEntry(textureId MSRDTextureId, highResFile XBC1) error {
+	if int(textureId) >= len(msrd.TextureInfoItems) {
+		return errors.New("Texture id " + fmt.Sprint(textureId) + " is out of range")
+	}
+	if _, found := msrd.TextureIdToIndexMap[textureId]; found {
+		return errors.New("Texture " + fmt.Sprint(textureId) + " is already streamed")
+	}
+	return msrd.addTextureStreamEntry(textureId, highResFile)
+}
+
+func (msrd *MSRD) addTextureStreamEntry(textureId MSRDTextureId, highResFile XBC1) error {
+	textureIndex := len(msrd.TextureIdToIndexMap)
+	fileIndex := len(msrd.CompressedFiles)
+	if fileIndex != MSRD_FILE_INDEX_TEXTURE_START+textureIndex {
+		return errors.New("Unexpected files after textures, cannot add texture")
+	}
+	if msrd.MetaHeader.TextureDataItemsCount == 0 {
+		msrd.MetaHeader.TextureDataItemsStart = uint32(len(msrd.DataItems))
+	}
+	if int(msrd.MetaHeader.TextureDataItemsStart+msrd.MetaHeader.TextureDataItemsCount) != len(msrd.DataItems) {
+		return errors.New("Unexpected data items after textures, cannot add texture")
+	}
+
+	msrd.TextureIdToIndexMap[textureId] = textureIndex
+	msrd.MetaHeader.TextureIdsCount = uint32(len(msrd.TextureIdToIndexMap))
+
+	msrd.DataItems = append(msrd.DataItems, MSRDDataItem{
+		FileIndexPlusOne: uint16(fileIndex + 1),
+		Type:             MSRD_DATA_ITEM_TYPE_TEXTURE,
+	})
+	msrd.MetaHeader.DataItemsCount = uint32(len(msrd.DataItems))
+	msrd.MetaHeader.TextureDataItemsCount++
+
+	highResFile.SetName(GetMSRDFileName(fileIndex))
+	msrd.CompressedFiles = append(msrd.CompressedFiles, nil)
+	msrd.SetCompressedFileData(fileIndex, highResFile)
+	msrd.MetaHeader.FileCount = uint32(len(msrd.CompressedFiles))
 
 	return nil
 }
+
+// AddTexture appends a streamed texture and saves its cached MIBL to the texture cache and its split mips to the mips
+// file
+func (msrd *MSRD) AddTexture(name string, usage MSRDTextureUsage, cacheMIBL, mipsMIBL MIBL, highResData []byte) (MSRDTextureId, error) {
+	cachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		return 0, err
+	}
+	splitMips, err := msrd.GetSplitMips()
+	if err != nil {
+		return 0, err
+	}
+
+	highResFile, err := CompressToXBC1WithLevel(msrd.GetCompressionType(), msrd.CompressionLevel, GetMSRDFileName(len(msrd.CompressedFiles)), highResData)
+	if err != nil {
+		return 0, errors.New("Error compressing texture: " + err.Error())
+	}
+	textureId, err := msrd.AddTextureEntry(name, usage, highResFile)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := msrd.SetCachedTextures(append(cachedTextures, cacheMIBL)); err != nil {
+		return 0, err
+	}
+	if err := msrd.SetMips(append(splitMips, mipsMIBL)); err != nil {
+		return 0, err
+	}
+	return textureId, nil
+}
+
+// GuessTextureUsage returns the usage of the textures whose name ends with the same _SUFFIX, like _NRM, or col when
+// no texture shares it
+func (msrd *MSRD) GuessTextureUsage(name string) MSRDTextureUsage {
+	suffixStart := strings.LastIndexByte(name, '_')
+	if suffixStart >= 0 {
+		for i, textureName := range msrd.TextureNames {
+			if strings.HasSuffix(textureName, name[suffixStart:]) && i < len(msrd.TextureInfoItems) {
+				return msrd.TextureInfoItems[i].Usage
+			}
+		}
+	}
+	return MSRD_TEXTURE_USAGE_COL
+}
+
+// Validate checks the file table against the xbc1 files, the data items against the files holding them and the
+// MIBLs of the textures, along with their alignments. Every problem found is returned, not just the first one.
+func (msrd *MSRD) Validate() []error {
+	var problems []error
+	metaDataEnd := msrd.Header.MetaDataOffset + msrd.Header.MetaDataSize
+	if metaDataEnd%MSRD_FILE_ALIGN != 0 {
+		problems = append(problems, errors.New("Metadata end "+fmt.Sprintf("0x%X", metaDataEnd)+" isn't aligned to "+
+			fmt.Sprintf("0x%X", MSRD_FILE_ALIGN)))
+	}
+
+	fileContents := make([][]byte, len(msrd.CompressedFiles))
+	previousEnd := metaDataEnd
+	for i, fileItem := range msrd.FileItems {
+		if fileItem.Offset%MSRD_FILE_ALIGN != 0 || fileItem.CompressedSize%MSRD_FILE_ALIGN != 0 {
+			problems = append(problems, errors.New("File "+fmt.Sprint(i)+" at "+fmt.Sprintf("0x%X", fileItem.Offset)+
+				" of "+fmt.Sprintf("0x%X", fileItem.CompressedSize)+" bytes isn't aligned to "+fmt.Sprintf("0x%X", MSRD_FILE_ALIGN)))
+		}
+		if fileItem.Offset < previousEnd {
+			problems = append(problems, errors.New("File "+fmt.Sprint(i)+" at "+fmt.Sprintf("0x%X", fileItem.Offset)+
+				" overlaps what comes before it, which ends at "+fmt.Sprintf("0x%X", previousEnd)))
+		}
+		previousEnd = fileItem.Offset + fileItem.CompressedSize
+		if i >= len(msrd.CompressedFiles) {
+			continue
+		}
+
+		header, content, err := ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[i]))
+		if err != nil {
+			problems = append(problems, errors.New("File "+fmt.Sprint(i)+": "+err.Error()))
+			continue
+		}
+		xbc1Size := uint32(binary.Size(header)) + header.CompressedSize
+		if xbc1Size > fileItem.CompressedSize || fileItem.CompressedSize-xbc1Size >= MSRD_FILE_ALIGN {
+			problems = append(problems, errors.New("File "+fmt.Sprint(i)+" has "+fmt.Sprint(fileItem.CompressedSize)+
+				" bytes in the file table, its xbc1 takes "+fmt.Sprint(xbc1Size)))
+		}
+		if header.UncompressedSize != fileItem.UncompressedSize {
+			problems = append(problems, errors.New("File "+fmt.Sprint(i)+" is "+fmt.Sprint(header.UncompressedSize)+
+				" bytes uncompressed, the file table says "+fmt.Sprint(fileItem.UncompressedSize)))
+		}
+		fileContents[i] = content
+	}
+
+	for i, dataItem := range msrd.DataItems {
+		fileIndex := msrd.GetDataItemFileIndex(i)
+		if fileIndex >= len(fileContents) || fileContents[fileIndex] == nil {
+			problems = append(problems, errors.New("Data item "+fmt.Sprint(i)+": file "+fmt.Sprint(fileIndex)+" can't be read"))
+			continue
+		}
+		if uint64(dataItem.Offset)+uint64(dataItem.Size) > uint64(len(fileContents[fileIndex])) {
+			problems = append(problems, errors.New("Data item "+fmt.Sprint(i)+" ("+dataItem.Type.String()+") at "+
+				fmt.Sprintf("0x%X", dataItem.Offset)+" of "+fmt.Sprintf("0x%X", dataItem.Size)+" bytes goes past the "+
+				fmt.Sprintf("0x%X", len(fileContents[fileIndex]))+" bytes of file "+fmt.Sprint(fileIndex)))
+			continue
+		}
+		if dataItem.Type != MSRD_DATA_ITEM_TYPE_TEXTURE {
+			continue
+		}
+		highResFileIndex := int(dataItem.FileIndexPlusOne) - 1
+		if highResFileIndex < MSRD_FILE_INDEX_TEXTURE_START || highResFileIndex >= len(msrd.CompressedFiles) {
+			problems = append(problems, errors.New("Data item "+fmt.Sprint(i)+" points to file "+fmt.Sprint(highResFileIndex)+
+				", which isn't a texture file"))
+		}
+		if dataItem.Offset%MIBL_ALIGN_SIZE != 0 {
+			problems = append(problems, errors.New("Data item "+fmt.Sprint(i)+" at "+fmt.Sprintf("0x%X", dataItem.Offset)+
+				" isn't aligned to "+fmt.Sprintf("0x%X", MIBL_ALIGN_SIZE)))
+		}
+		mibl := MIBL(fileContents[fileIndex][dataItem.Offset : dataItem.Offset+dataItem.Size])
+		if err := mibl.Validate(); err != nil {
+			problems = append(problems, errors.New("Mips of data item "+fmt.Sprint(i)+": "+err.Error()))
+		}
+	}
+
+	for _, dataItemType := range []MSRDDataItemType{MSRD_DATA_ITEM_TYPE_MODEL, MSRD_DATA_ITEM_TYPE_SHADERBUNDLE} {
+		if _, err := msrd.GetHeaderDataItemIndex(dataItemType); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	cacheIndex, err := msrd.GetHeaderDataItemIndex(MSRD_DATA_ITEM_TYPE_TEXTURECACHE)
+	if err != nil {
+		return append(problems, err)
+	}
+	cacheItem := msrd.DataItems[cacheIndex]
+	// the data items above already report a cache that can't be read
+	cacheFileIndex := msrd.GetDataItemFileIndex(cacheIndex)
+	if cacheFileIndex >= len(fileContents) || fileContents[cacheFileIndex] == nil ||
+		uint64(cacheItem.Offset)+uint64(cacheItem.Size) > uint64(len(fileContents[cacheFileIndex])) {
+		return problems
+	}
+	cacheFileContent := fileContents[cacheFileIndex]
+	for i, textureInfoItem := range msrd.TextureInfoItems {
+		name := "Cached texture " + fmt.Sprint(i)
+		if i < len(msrd.TextureNames) {
+			name += " (" + msrd.TextureNames[i] + ")"
+		}
+		if uint64(textureInfoItem.CacheOffset)+uint64(textureInfoItem.CacheSize) > uint64(cacheItem.Size) {
+			problems = append(problems, errors.New(name+" goes past the texture cache data item"))
+			continue
+		}
+		if textureInfoItem.CacheOffset%MIBL_ALIGN_SIZE != 0 {
+			problems = append(problems, errors.New(name+" at "+fmt.Sprintf("0x%X", textureInfoItem.CacheOffset)+
+				" isn't aligned to "+fmt.Sprintf("0x%X", MIBL_ALIGN_SIZE)))
+		}
+		start := cacheItem.Offset + textureInfoItem.CacheOffset
+		mibl := MIBL(cacheFileContent[start : start+textureInfoItem.CacheSize])
+		if err := mibl.Validate(); err != nil {
+			problems = append(problems, errors.New(name+": "+err.Error()))
+		}
+	}
+	return problems
+}
+
+// GetCompressionType gives the compression type of the first file, which new files follow since the games don't mix
+// types within a wismt
+func (msrd *MSRD) GetCompressionType() XBC1CompressionType {
+	if len(msrd.CompressedFiles) > 0 {
+		if header, err := ReadXBC1Header(bytes.NewReader(msrd.CompressedFiles[0])); err == nil {
+			return header.CompressionType
+		}
+	}
+	return XBC1_COMPRESSION_TYPE_ZLIB
+}
+
+// files are named after their index in the games
+func GetMSRDFileName(fileIndex int) [0x1C]byte {
+	var name [0x1C]byte
+	copy(name[:], fmt.Sprintf("%04d", fileIndex))
+	return name
+}
@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"reflect"
 
 	"github.com/3096/furnace/furnace"
+	"github.com/3096/furnace/utils"
 )
 
 const MXMD_MAGIC uint32 = 'M'<<24 | 'X'<<16 | 'M'<<8 | 'D'
@@ -26,6 +30,8 @@ type MXMDHeader struct {
 
 type MXMD []byte
 
+const MXMD_ALIGN uint32 = 0x10
+
 func (mxmd *MXMD) GetHeader() (*MXMDHeader, error) {
 	var header MXMDHeader
 	err := binary.Read(bytes.NewReader(*mxmd), furnace.TargetByteOrder, &header)
@@ -35,6 +41,876 @@ func (mxmd *MXMD) GetHeader() (*MXMDHeader, error) {
 	return &header, nil
 }
 
+// GetUncachedTextures parses the copy of the msrd metadata embedded at UncachedTexturesOffset
+func (mxmd *MXMD) GetUncachedTextures() (MSRDMetaDataTables, error) {
+	header, err := mxmd.GetHeader()
+	if err != nil {
+		return MSRDMetaDataTables{}, errors.New("Error reading mxmd header: " + err.Error())
+	}
+	if header.UncachedTexturesOffset == 0 || header.UncachedTexturesOffset >= uint32(len(*mxmd)) {
+		return MSRDMetaDataTables{}, errors.New("No uncached textures in mxmd")
+	}
+	return ReadMSRDMetaData(MSRDMetaData((*mxmd)[header.UncachedTexturesOffset:]))
+}
+
+// CheckUncachedTextures makes sure the metadata embedded at UncachedTexturesOffset is the same as metaData, which
+// isn't the case when the wimdo doesn't belong to the wismt
+func (mxmd *MXMD) CheckUncachedTextures(metaData MSRDMetaData) error {
+	header, err := mxmd.GetHeader()
+	if err != nil {
+		return errors.New("Error reading mxmd header: " + err.Error())
+	}
+	if header.UncachedTexturesOffset == 0 {
+		return errors.New("No uncached textures in mxmd")
+	}
+	start := header.UncachedTexturesOffset
+	if uint64(start)+uint64(len(metaData)) > uint64(len(*mxmd)) {
+		return errors.New("Wimdo uncached textures region is " + fmt.Sprint(int64(len(*mxmd))-int64(start)) +
+			" bytes, smaller than the " + fmt.Sprint(len(metaData)) + " bytes of wismt metadata")
+	}
+	if err := CompareMSRDMetaData(metaData, MSRDMetaData((*mxmd)[start:start+uint32(len(metaData))])); err != nil {
+		return errors.New("Wimdo uncached textures don't match the wismt metadata: " + err.Error())
+	}
+	return nil
+}
+
+// SetUncachedTextures replaces the msrd metadata embedded at UncachedTexturesOffset. origMetaData is the metadata
+// of the wismt before it was edited, the embedded copy has to match it. When the table layout didn't change, only the
+// tables and names are written over the copy. Otherwise the copy is replaced, and it can only grow or shrink when
+// nothing but padding follows it in the wimdo.
+func (mxmd *MXMD) SetUncachedTextures(origMetaData, metaData MSRDMetaData) error {
+	if err := mxmd.CheckUncachedTextures(origMetaData); err != nil {
+		return err
+	}
+	header, err := mxmd.GetHeader()
+	if err != nil {
+		return errors.New("Error reading mxmd header: " + err.Error())
+	}
+	start := header.UncachedTexturesOffset
+	end := start + uint32(len(origMetaData))
+	region := MSRDMetaData((*mxmd)[start:end])
+
+	oldTables, err := ReadMSRDMetaData(region)
+	if err != nil {
+		return errors.New("Error reading mxmd uncached textures: " + err.Error())
+	}
+	tables, err := ReadMSRDMetaData(metaData)
+	if err != nil {
+		return errors.New("Error reading new msrd metadata: " + err.Error())
+	}
+	if len(metaData) == len(region) && tables.MetaHeader == oldTables.MetaHeader &&
+		tables.TextureInfoHeader == oldTables.TextureInfoHeader {
+		if err := tables.Write(region); err != nil {
+			return err
+		}
+		// what follows the tables is the names and the padding
+		namesStart := tables.MetaHeader.TextureInfoOffset + tables.TextureInfoHeader.TextureNamesOffset
+		if namesStart < uint32(len(region)) {
+			copy(region[namesStart:], metaData[namesStart:])
+		}
+		return nil
+	}
+
+	for _, offset := range []uint32{header.ModelsOffset, header.MaterialsOffset, header.VertexBufferOffset,
+		header.ShadersOffset, header.CachedTexturesOffset} {
+		if offset > start {
+			return mxmdRegionTooSmallError(uint32(len(region)), metaData)
+		}
+	}
+	for _, b := range (*mxmd)[end:] {
+		if b != 0 {
+			return mxmdRegionTooSmallError(uint32(len(region)), metaData)
+		}
+	}
+
+	resized := append(append(MXMD{}, (*mxmd)[:start]...), metaData...)
+	resized = append(resized, make(MXMD, utils.Align(uint32(len(resized)), MXMD_ALIGN)-uint32(len(resized)))...)
+	*mxmd = resized
+	return nil
+}
+
+func mxmdRegionTooSmallError(regionSize uint32, metaData MSRDMetaData) error {
+	if uint32(len(metaData)) > regionSize {
+		return errors.New("Wimdo uncached textures region is " + fmt.Sprint(regionSize) + " bytes, too small for the " +
+			fmt.Sprint(len(metaData)) + " bytes of new wismt metadata, and data follows it in the wimdo")
+	}
+	return errors.New("Wimdo uncached textures change layout and data follows them in the wimdo, cannot resize them")
+}
+
+// MXMD_VERSION is the only version the typed sections are known for
+const MXMD_VERSION uint32 = 10112
+
+type MXMDModelsHeader struct {
+	Flags          uint32
+	MaxXYZ         [3]float32
+	MinXYZ         [3]float32
+	ModelsOffset   uint32
+	ModelCount     uint32
+	Unk0           uint32
+	SkinningOffset uint32
+	Unk1           [31]uint32
+}
+
+type MXMDModelItem struct {
+	MeshesOffset   uint32
+	MeshCount      uint32
+	Unk0           uint32
+	MaxXYZ         [3]float32
+	MinXYZ         [3]float32
+	BoundingRadius float32
+	Unk1           [7]uint32
+}
+
+type MXMDMesh struct {
+	Flags1            uint32
+	Flags2            uint32
+	VertexBufferIndex uint16
+	IndexBufferIndex  uint16
+	Unk0              uint16
+	MaterialIndex     uint16
+	Unk1              [8]uint32
+}
+
+type MXMDModel struct {
+	MXMDModelItem
+	Meshes []MXMDMesh
+}
+
+type MXMDSkinningHeader struct {
+	RenderBoneCount             uint32
+	BoneCount                   uint32
+	BonesOffset                 uint32
+	InverseBindTransformsOffset uint32
+	Unk                         [6]uint32
+}
+
+type MXMDBone struct {
+	NameOffset uint32
+	Unk0       float32
+	Type       uint32
+	Index      uint32
+	Unk1       [2]uint32
+}
+
+// MXMDSkinning offsets are relative to the skinning header
+type MXMDSkinning struct {
+	Header                MXMDSkinningHeader
+	Bones                 []MXMDBone
+	BoneNames             []string
+	InverseBindTransforms [][4][4]float32
+}
+
+// MXMDModels offsets are relative to the models header
+type MXMDModels struct {
+	Header   MXMDModelsHeader
+	Models   []MXMDModel
+	Skinning *MXMDSkinning
+}
+
+type MXMDMaterialsHeader struct {
+	MaterialsOffset  uint32
+	MaterialCount    uint32
+	Unk0             [2]uint32
+	WorkValuesOffset uint32
+	WorkValueCount   uint32
+	ShaderVarsOffset uint32
+	ShaderVarCount   uint32
+	CallbacksOffset  uint32
+	Unk1             uint32
+	TechniquesOffset uint32
+	TechniqueCount   uint32
+	Unk2             [16]uint32
+}
+
+type MXMDMaterialItem struct {
+	NameOffset       uint32
+	Flags            uint32
+	RenderFlags      uint32
+	Color            [4]float32
+	AlphaTestRef     float32
+	TexturesOffset   uint32
+	TextureCount     uint32
+	StateFlags       [8]byte
+	Unk0             [4]uint32
+	WorkValueStart   uint32
+	ShaderVarStart   uint32
+	ShaderVarCount   uint32
+	TechniquesOffset uint32
+	TechniqueCount   uint32
+	Unk1             uint32
+	CallbackStart    uint16
+	CallbackCount    uint16
+	Unk2             [4]uint16
+	Unk3             [8]uint16
+}
+
+// MXMDMaterialTexture points into the textures of the wismt, cached ones first
+type MXMDMaterialTexture struct {
+	TextureIndex uint16
+	Unk          [3]uint16
+}
+
+// MXMDMaterialTechnique points into the techniques of MXMDMaterials
+type MXMDMaterialTechnique struct {
+	TechniqueIndex uint32
+	Unk0           [2]uint16
+	Unk1           uint32
+}
+
+type MXMDMaterial struct {
+	Name string
+	MXMDMaterialItem
+	Textures   []MXMDMaterialTexture
+	Techniques []MXMDMaterialTechnique
+}
+
+type MXMDMaterialCallbacksHeader struct {
+	WorkCallbacksOffset   uint32
+	WorkCallbackCount     uint32
+	MaterialIndicesOffset uint32
+	MaterialIndexCount    uint32
+	Unk                   [8]uint32
+}
+
+type MXMDMaterialCallbacks struct {
+	Header          MXMDMaterialCallbacksHeader
+	WorkCallbacks   [][2]uint16
+	MaterialIndices []uint16
+}
+
+type MXMDTechniqueItem struct {
+	AttributesOffset     uint32
+	AttributeCount       uint32
+	Unk0                 [2]uint32
+	ParametersOffset     uint32
+	ParameterCount       uint32
+	TexturesOffset       uint32
+	TextureCount         uint32
+	UniformBlocksOffset  uint32
+	UniformBlockCount    uint32
+	MaterialTextureCount uint32
+	Unk1                 [2]uint16
+	Unk2                 [5]uint32
+}
+
+type MXMDTechniqueParameter struct {
+	Type           uint16
+	WorkValueIndex uint16
+	Unk            uint16
+	Count          uint16
+}
+
+type MXMDTechniqueAttribute struct {
+	Type uint16
+	Unk0 uint16
+	Unk1 uint32
+}
+
+// MXMDTechnique is a shader program setup shared by materials, with the vertex attributes it reads
+type MXMDTechnique struct {
+	MXMDTechniqueItem
+	Attributes    []MXMDTechniqueAttribute
+	Parameters    []MXMDTechniqueParameter
+	Textures      []uint16
+	UniformBlocks [][2]uint16
+}
+
+// mxmdTable is a table of a section, value points to its slice
+type mxmdTable struct {
+	offset uint32
+	count  uint32
+	value  interface{}
+}
+
+func (technique *MXMDTechnique) getTables() []mxmdTable {
+	return []mxmdTable{
+		{technique.AttributesOffset, technique.AttributeCount, &technique.Attributes},
+		{technique.ParametersOffset, technique.ParameterCount, &technique.Parameters},
+		{technique.TexturesOffset, technique.TextureCount, &technique.Textures},
+		{technique.UniformBlocksOffset, technique.UniformBlockCount, &technique.UniformBlocks},
+	}
+}
+
+// MXMDMaterials offsets are relative to the materials header
+type MXMDMaterials struct {
+	Header     MXMDMaterialsHeader
+	Materials  []MXMDMaterial
+	WorkValues []float32
+	ShaderVars [][2]uint16
+	Callbacks  *MXMDMaterialCallbacks
+	Techniques []MXMDTechnique
+}
+
+// MXMDRegion is a part of the wimdo that no section parses, kept as is
+type MXMDRegion struct {
+	Offset uint32
+	Data   []byte
+}
+
+// MXMDFile is the wimdo parsed into typed sections, a nil section is absent from the wimdo. Only the sections found in
+// a game wimdo are parsed, the vertex data, shaders and cached textures stay in Unparsed. GetMXMD writes the wimdo back
+// from them, so tables can be moved and resized by changing their offsets and counts.
+type MXMDFile struct {
+	Header           MXMDHeader
+	Models           *MXMDModels
+	Materials        *MXMDMaterials
+	UncachedTextures *MSRDMetaDataTables
+	// bytes none of the sections read, like the sections only known by their offset in the header and the tables the
+	// parsed ones point to through unknown fields. They're written back at the same offsets, what isn't in them or in
+	// a section is padding and written as 0.
+	Unparsed []MXMDRegion `json:"-"`
+	// size of the wimdo, it has to be raised to move tables past its end
+	Size uint32
+}
+
+// mxmdBuffer is a wimdo being read or written. It keeps track of the bytes already used: what parsing didn't read
+// becomes MXMDFile.Unparsed, and writing finds tables overlapping with different content.
+type mxmdBuffer struct {
+	data []byte
+	used []bool
+}
+
+func newMXMDBuffer(data []byte) *mxmdBuffer {
+	return &mxmdBuffer{data: data, used: make([]bool, len(data))}
+}
+
+// mxmdSection reads and writes a section of the wimdo, at offsets relative to its start
+type mxmdSection struct {
+	buffer *mxmdBuffer
+	start  uint32
+}
+
+func (buffer *mxmdBuffer) getSection(offset uint32) (mxmdSection, error) {
+	if offset >= uint32(len(buffer.data)) {
+		return mxmdSection{}, errors.New("offset " + fmt.Sprintf("0x%X", offset) + " is past the " +
+			fmt.Sprintf("0x%X", len(buffer.data)) + " bytes of the data")
+	}
+	return mxmdSection{buffer: buffer, start: offset}, nil
+}
+
+func (section mxmdSection) getSection(offset uint32) (mxmdSection, error) {
+	if uint64(section.start)+uint64(offset) > math.MaxUint32 {
+		return mxmdSection{}, errors.New("offset " + fmt.Sprintf("0x%X", offset) + " is out of range")
+	}
+	return section.buffer.getSection(section.start + offset)
+}
+
+func (section mxmdSection) getRange(offset uint32, size int) (int, int, error) {
+	start := uint64(section.start) + uint64(offset)
+	end := start + uint64(size)
+	if end > uint64(len(section.buffer.data)) {
+		return 0, 0, errors.New(fmt.Sprint(size) + " bytes at offset " + fmt.Sprintf("0x%X", start) +
+			" go past the " + fmt.Sprintf("0x%X", len(section.buffer.data)) + " bytes of the data")
+	}
+	return int(start), int(end), nil
+}
+
+func (section mxmdSection) readBytes(offset uint32, size uint32) ([]byte, error) {
+	start, end, err := section.getRange(offset, int(size))
+	if err != nil {
+		return nil, err
+	}
+	for i := start; i < end; i++ {
+		section.buffer.used[i] = true
+	}
+	return append([]byte{}, section.buffer.data[start:end]...), nil
+}
+
+func (section mxmdSection) read(offset uint32, value interface{}) error {
+	size := binary.Size(value)
+	if size < 0 {
+		return errors.New("cannot read " + fmt.Sprintf("%T", value))
+	}
+	data, err := section.readBytes(offset, uint32(size))
+	if err != nil {
+		return err
+	}
+	return binary.Read(bytes.NewReader(data), furnace.TargetByteOrder, value)
+}
+
+func (section mxmdSection) readCString(offset uint32) (string, error) {
+	start, _, err := section.getRange(offset, 0)
+	if err != nil || start >= len(section.buffer.data) {
+		return "", errors.New("name offset " + fmt.Sprintf("0x%X", offset) + " out of bounds")
+	}
+	length := bytes.IndexByte(section.buffer.data[start:], 0)
+	if length < 0 {
+		return "", errors.New("unterminated name at offset " + fmt.Sprintf("0x%X", start))
+	}
+	name, err := section.readBytes(offset, uint32(length)+1)
+	if err != nil {
+		return "", err
+	}
+	return string(name[:length]), nil
+}
+
+// writeBytes fails when the data goes past the end of the buffer or over bytes already written with other values
+func (section mxmdSection) writeBytes(offset uint32, data []byte) error {
+	start, end, err := section.getRange(offset, len(data))
+	if err != nil {
+		return err
+	}
+	for i := start; i < end; i++ {
+		if section.buffer.used[i] && section.buffer.data[i] != data[i-start] {
+			return errors.New(fmt.Sprint(len(data)) + " bytes at offset " + fmt.Sprintf("0x%X", start) +
+				" overlap other data at " + fmt.Sprintf("0x%X", i))
+		}
+		section.buffer.used[i] = true
+	}
+	copy(section.buffer.data[start:end], data)
+	return nil
+}
+
+func (section mxmdSection) write(offset uint32, value interface{}) error {
+	data := bytes.Buffer{}
+	if err := binary.Write(&data, furnace.TargetByteOrder, value); err != nil {
+		return errors.New("cannot write " + fmt.Sprintf("%T", value) + ": " + err.Error())
+	}
+	return section.writeBytes(offset, data.Bytes())
+}
+
+func (section mxmdSection) writeCString(offset uint32, name string) error {
+	return section.writeBytes(offset, append([]byte(name), 0))
+}
+
+// getUnused returns the runs of unused bytes that aren't all 0, trimmed of their leading and trailing 0s
+func (buffer *mxmdBuffer) getUnused() []MXMDRegion {
+	var regions []MXMDRegion
+	for i := 0; i < len(buffer.data); i++ {
+		if buffer.used[i] || buffer.data[i] == 0 {
+			continue
+		}
+		end := i
+		for j := i; j < len(buffer.data) && !buffer.used[j]; j++ {
+			if buffer.data[j] != 0 {
+				end = j + 1
+			}
+		}
+		regions = append(regions, MXMDRegion{Offset: uint32(i), Data: append([]byte{}, buffer.data[i:end]...)})
+		i = end
+	}
+	return regions
+}
+
+// ParseMXMD reads the typed sections of the wimdo
+func ParseMXMD(mxmd MXMD) (MXMDFile, error) {
+	file := MXMDFile{Size: uint32(len(mxmd))}
+	buffer := newMXMDBuffer(mxmd)
+	root := mxmdSection{buffer: buffer}
+	if err := root.read(0, &file.Header); err != nil {
+		return MXMDFile{}, errors.New("Error reading mxmd header: " + err.Error())
+	}
+	if file.Header.Magic != MXMD_MAGIC {
+		return MXMDFile{}, errors.New("Invalid mxmd magic " + fmt.Sprintf("0x%08X", file.Header.Magic))
+	}
+	if file.Header.Version != MXMD_VERSION {
+		return MXMDFile{}, errors.New("Unsupported mxmd version " + fmt.Sprint(file.Header.Version) + ", expected " +
+			fmt.Sprint(MXMD_VERSION))
+	}
+
+	for _, section := range []struct {
+		name   string
+		offset uint32
+		read   func(section mxmdSection) error
+	}{
+		{"models", file.Header.ModelsOffset, func(section mxmdSection) (err error) {
+			file.Models, err = readMXMDModels(section)
+			return err
+		}},
+		{"materials", file.Header.MaterialsOffset, func(section mxmdSection) (err error) {
+			file.Materials, err = readMXMDMaterials(section)
+			return err
+		}},
+		{"uncached textures", file.Header.UncachedTexturesOffset, func(section mxmdSection) (err error) {
+			file.UncachedTextures, err = readMXMDUncachedTextures(section)
+			return err
+		}},
+	} {
+		if section.offset == 0 {
+			continue
+		}
+		sectionReader, err := buffer.getSection(section.offset)
+		if err == nil {
+			err = section.read(sectionReader)
+		}
+		if err != nil {
+			return MXMDFile{}, errors.New("Error reading mxmd " + section.name + ": " + err.Error())
+		}
+	}
+
+	file.Unparsed = buffer.getUnused()
+	return file, nil
+}
+
+// readTable makes the slice table points to count items long and reads it at offset
+func (section mxmdSection) readTable(offset uint32, count uint32, table interface{}) error {
+	slice := reflect.ValueOf(table).Elem()
+	itemSize := binary.Size(reflect.Zero(slice.Type().Elem()).Interface())
+	// checked before making the table, a bad count would make it huge
+	if _, _, err := section.getRange(offset, 0); err != nil {
+		return err
+	}
+	if uint64(count)*uint64(itemSize) > uint64(len(section.buffer.data)) {
+		return errors.New(fmt.Sprint(count) + " items at offset " + fmt.Sprintf("0x%X", offset) +
+			" go past the end of the data")
+	}
+	slice.Set(reflect.MakeSlice(slice.Type(), int(count), int(count)))
+	return section.read(offset, slice.Interface())
+}
+
+func readMXMDModels(section mxmdSection) (*MXMDModels, error) {
+	models := MXMDModels{}
+	if err := section.read(0, &models.Header); err != nil {
+		return nil, err
+	}
+	var items []MXMDModelItem
+	err := section.readTable(models.Header.ModelsOffset, models.Header.ModelCount, &items)
+	if err != nil {
+		return nil, err
+	}
+	models.Models = make([]MXMDModel, len(items))
+	for i, item := range items {
+		models.Models[i].MXMDModelItem = item
+		if err = section.readTable(item.MeshesOffset, item.MeshCount, &models.Models[i].Meshes); err != nil {
+			return nil, errors.New("model " + fmt.Sprint(i) + ": " + err.Error())
+		}
+	}
+
+	if models.Header.SkinningOffset != 0 {
+		skinningSection, err := section.getSection(models.Header.SkinningOffset)
+		if err != nil {
+			return nil, errors.New("skinning " + err.Error())
+		}
+		skinning := MXMDSkinning{}
+		if err := skinningSection.read(0, &skinning.Header); err != nil {
+			return nil, err
+		}
+		header := &skinning.Header
+		if err = skinningSection.readTable(header.BonesOffset, header.BoneCount, &skinning.Bones); err != nil {
+			return nil, err
+		}
+		skinning.BoneNames = make([]string, len(skinning.Bones))
+		for i, bone := range skinning.Bones {
+			if skinning.BoneNames[i], err = skinningSection.readCString(bone.NameOffset); err != nil {
+				return nil, errors.New("bone " + fmt.Sprint(i) + ": " + err.Error())
+			}
+		}
+		err = skinningSection.readTable(header.InverseBindTransformsOffset, header.BoneCount,
+			&skinning.InverseBindTransforms)
+		if err != nil {
+			return nil, err
+		}
+		models.Skinning = &skinning
+	}
+	return &models, nil
+}
+
+func readMXMDMaterials(section mxmdSection) (*MXMDMaterials, error) {
+	materials := MXMDMaterials{}
+	if err := section.read(0, &materials.Header); err != nil {
+		return nil, err
+	}
+	header := &materials.Header
+	var items []MXMDMaterialItem
+	err := section.readTable(header.MaterialsOffset, header.MaterialCount, &items)
+	if err != nil {
+		return nil, err
+	}
+	materials.Materials = make([]MXMDMaterial, len(items))
+	for i, item := range items {
+		material := &materials.Materials[i]
+		material.MXMDMaterialItem = item
+		if material.Name, err = section.readCString(item.NameOffset); err != nil {
+			return nil, errors.New("material " + fmt.Sprint(i) + ": " + err.Error())
+		}
+		if err = section.readTable(item.TexturesOffset, item.TextureCount, &material.Textures); err != nil {
+			return nil, errors.New("material " + fmt.Sprint(i) + ": " + err.Error())
+		}
+		err = section.readTable(item.TechniquesOffset, item.TechniqueCount, &material.Techniques)
+		if err != nil {
+			return nil, errors.New("material " + fmt.Sprint(i) + ": " + err.Error())
+		}
+	}
+	if err = section.readTable(header.WorkValuesOffset, header.WorkValueCount, &materials.WorkValues); err != nil {
+		return nil, err
+	}
+	if err = section.readTable(header.ShaderVarsOffset, header.ShaderVarCount, &materials.ShaderVars); err != nil {
+		return nil, err
+	}
+
+	if header.CallbacksOffset != 0 {
+		callbacks := MXMDMaterialCallbacks{}
+		if err := section.read(header.CallbacksOffset, &callbacks.Header); err != nil {
+			return nil, errors.New("callbacks: " + err.Error())
+		}
+		callbacksHeader := &callbacks.Header
+		err = section.readTable(callbacksHeader.WorkCallbacksOffset,
+			callbacksHeader.WorkCallbackCount, &callbacks.WorkCallbacks)
+		if err != nil {
+			return nil, errors.New("callbacks: " + err.Error())
+		}
+		err = section.readTable(callbacksHeader.MaterialIndicesOffset,
+			callbacksHeader.MaterialIndexCount, &callbacks.MaterialIndices)
+		if err != nil {
+			return nil, errors.New("callbacks: " + err.Error())
+		}
+		materials.Callbacks = &callbacks
+	}
+
+	var techniqueItems []MXMDTechniqueItem
+	err = section.readTable(header.TechniquesOffset, header.TechniqueCount, &techniqueItems)
+	if err != nil {
+		return nil, err
+	}
+	materials.Techniques = make([]MXMDTechnique, len(techniqueItems))
+	for i, item := range techniqueItems {
+		technique := &materials.Techniques[i]
+		technique.MXMDTechniqueItem = item
+		for _, table := range technique.getTables() {
+			if err := section.readTable(table.offset, table.count, table.value); err != nil {
+				return nil, errors.New("technique " + fmt.Sprint(i) + ": " + err.Error())
+			}
+		}
+	}
+	return &materials, nil
+}
+
+// readMXMDUncachedTextures reads the copy of the msrd metadata, offsets are relative to its start like in the wismt
+func readMXMDUncachedTextures(section mxmdSection) (*MSRDMetaDataTables, error) {
+	tables, err := ReadMSRDMetaData(MSRDMetaData(section.buffer.data[section.start:]))
+	if err != nil {
+		return nil, err
+	}
+	layout, err := tables.getLayout()
+	if err != nil {
+		return nil, err
+	}
+	// read them again to know which bytes they cover
+	for _, table := range layout {
+		if _, err := section.readBytes(table.offset, uint32(binary.Size(table.value))); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range tables.TextureInfoItems {
+		if _, err := section.readCString(tables.MetaHeader.TextureInfoOffset + item.NameOffset); err != nil {
+			return nil, err
+		}
+	}
+	return &tables, nil
+}
+
+// GetMXMD writes the wimdo from the typed sections and the unparsed regions. Sections and tables are written at the
+// offsets their headers give, so changing an offset moves a table, and a table can grow once it's moved to free
+// space. Tables that end up overlapping other data, or past Size, are an error.
+func (file *MXMDFile) GetMXMD() (MXMD, error) {
+	buffer := newMXMDBuffer(make([]byte, file.Size))
+	root := mxmdSection{buffer: buffer}
+	if err := root.write(0, &file.Header); err != nil {
+		return nil, errors.New("Error writing mxmd header: " + err.Error())
+	}
+
+	for _, section := range []struct {
+		name    string
+		offset  uint32
+		present bool
+		write   func(section mxmdSection) error
+	}{
+		{"models", file.Header.ModelsOffset, file.Models != nil, func(section mxmdSection) error {
+			return file.Models.write(section)
+		}},
+		{"materials", file.Header.MaterialsOffset, file.Materials != nil, func(section mxmdSection) error {
+			return file.Materials.write(section)
+		}},
+		{"uncached textures", file.Header.UncachedTexturesOffset, file.UncachedTextures != nil, func(section mxmdSection) error {
+			return writeMXMDUncachedTextures(section, file.UncachedTextures)
+		}},
+	} {
+		if section.present != (section.offset != 0) {
+			return nil, errors.New("Error writing mxmd " + section.name + ": the header offset " +
+				fmt.Sprintf("0x%X", section.offset) + " doesn't match whether the section is set")
+		}
+		if !section.present {
+			continue
+		}
+		sectionWriter, err := buffer.getSection(section.offset)
+		if err == nil {
+			err = section.write(sectionWriter)
+		}
+		if err != nil {
+			return nil, errors.New("Error writing mxmd " + section.name + ": " + err.Error())
+		}
+	}
+
+	for _, region := range file.Unparsed {
+		if err := root.writeBytes(region.Offset, region.Data); err != nil {
+			return nil, errors.New("Error writing unparsed mxmd data: " + err.Error())
+		}
+	}
+	return buffer.data, nil
+}
+
+// writeTable writes the slice table is or points to at offset, it has to be count items long
+func (section mxmdSection) writeTable(offset uint32, count uint32, table interface{}) error {
+	slice := reflect.Indirect(reflect.ValueOf(table))
+	if slice.Len() != int(count) {
+		return errors.New(fmt.Sprint(slice.Len()) + " items of " + slice.Type().Elem().String() + " for a count of " +
+			fmt.Sprint(count))
+	}
+	return section.write(offset, slice.Interface())
+}
+
+func (models *MXMDModels) write(section mxmdSection) error {
+	if err := section.write(0, &models.Header); err != nil {
+		return err
+	}
+	items := make([]MXMDModelItem, len(models.Models))
+	for i, model := range models.Models {
+		items[i] = model.MXMDModelItem
+		if err := section.writeTable(model.MeshesOffset, model.MeshCount, model.Meshes); err != nil {
+			return errors.New("model " + fmt.Sprint(i) + ": " + err.Error())
+		}
+	}
+	if err := section.writeTable(models.Header.ModelsOffset, models.Header.ModelCount, items); err != nil {
+		return err
+	}
+
+	skinning := models.Skinning
+	if (skinning != nil) != (models.Header.SkinningOffset != 0) {
+		return errors.New("skinning offset " + fmt.Sprintf("0x%X", models.Header.SkinningOffset) + " doesn't match the skinning")
+	}
+	if skinning == nil {
+		return nil
+	}
+	skinningSection, err := section.getSection(models.Header.SkinningOffset)
+	if err != nil {
+		return errors.New("skinning " + err.Error())
+	}
+	header := &skinning.Header
+	if len(skinning.BoneNames) != len(skinning.Bones) {
+		return errors.New("bone names don't match the bones")
+	}
+	if err := skinningSection.write(0, header); err != nil {
+		return err
+	}
+	if err := skinningSection.writeTable(header.BonesOffset, header.BoneCount, skinning.Bones); err != nil {
+		return err
+	}
+	for i, bone := range skinning.Bones {
+		if err := skinningSection.writeCString(bone.NameOffset, skinning.BoneNames[i]); err != nil {
+			return errors.New("bone " + fmt.Sprint(i) + ": " + err.Error())
+		}
+	}
+	return skinningSection.writeTable(header.InverseBindTransformsOffset, header.BoneCount, skinning.InverseBindTransforms)
+}
+
+func (materials *MXMDMaterials) write(section mxmdSection) error {
+	header := &materials.Header
+	if err := section.write(0, header); err != nil {
+		return err
+	}
+	items := make([]MXMDMaterialItem, len(materials.Materials))
+	for i, material := range materials.Materials {
+		items[i] = material.MXMDMaterialItem
+		err := section.writeCString(material.NameOffset, material.Name)
+		if err == nil {
+			err = section.writeTable(material.TexturesOffset, material.TextureCount, material.Textures)
+		}
+		if err == nil {
+			err = section.writeTable(material.TechniquesOffset, material.TechniqueCount, material.Techniques)
+		}
+		if err != nil {
+			return errors.New("material " + fmt.Sprint(i) + ": " + err.Error())
+		}
+	}
+	if err := section.writeTable(header.MaterialsOffset, header.MaterialCount, items); err != nil {
+		return err
+	}
+	if err := section.writeTable(header.WorkValuesOffset, header.WorkValueCount, materials.WorkValues); err != nil {
+		return err
+	}
+	if err := section.writeTable(header.ShaderVarsOffset, header.ShaderVarCount, materials.ShaderVars); err != nil {
+		return err
+	}
+
+	if callbacks := materials.Callbacks; callbacks != nil {
+		if header.CallbacksOffset == 0 {
+			return errors.New("callbacks offset is 0")
+		}
+		callbacksHeader := &callbacks.Header
+		err := section.write(header.CallbacksOffset, callbacksHeader)
+		if err == nil {
+			err = section.writeTable(callbacksHeader.WorkCallbacksOffset, callbacksHeader.WorkCallbackCount,
+				callbacks.WorkCallbacks)
+		}
+		if err == nil {
+			err = section.writeTable(callbacksHeader.MaterialIndicesOffset, callbacksHeader.MaterialIndexCount,
+				callbacks.MaterialIndices)
+		}
+		if err != nil {
+			return errors.New("callbacks: " + err.Error())
+		}
+	} else if header.CallbacksOffset != 0 {
+		return errors.New("callbacks offset " + fmt.Sprintf("0x%X", header.CallbacksOffset) + " without callbacks")
+	}
+
+	techniqueItems := make([]MXMDTechniqueItem, len(materials.Techniques))
+	for i := range materials.Techniques {
+		technique := &materials.Techniques[i]
+		techniqueItems[i] = technique.MXMDTechniqueItem
+		for _, table := range technique.getTables() {
+			if err := section.writeTable(table.offset, table.count, table.value); err != nil {
+				return errors.New("technique " + fmt.Sprint(i) + ": " + err.Error())
+			}
+		}
+	}
+	return section.writeTable(header.TechniquesOffset, header.TechniqueCount, techniqueItems)
+}
+
+func writeMXMDUncachedTextures(section mxmdSection, tables *MSRDMetaDataTables) error {
+	layout, err := tables.getLayout()
+	if err != nil {
+		return err
+	}
+	if len(tables.TextureNames) != len(tables.TextureInfoItems) {
+		return errors.New("texture names don't match the textures")
+	}
+	for _, table := range layout {
+		if err := section.write(table.offset, table.value); err != nil {
+			return err
+		}
+	}
+	for i, item := range tables.TextureInfoItems {
+		if err := section.writeCString(tables.MetaHeader.TextureInfoOffset+item.NameOffset, tables.TextureNames[i]); err != nil {
+			return errors.New("texture " + fmt.Sprint(i) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// readStructAt reads value from data at offset, value has to fit
+func readStructAt(data []byte, offset uint32, value interface{}) error {
+	size := binary.Size(value)
+	if size < 0 {
+		return errors.New("cannot read " + fmt.Sprintf("%T", value))
+	}
+	if uint64(offset)+uint64(size) > uint64(len(data)) {
+		return errors.New(fmt.Sprint(size) + " bytes at offset " + fmt.Sprint(offset) + " go past the end of the data")
+	}
+	return binary.Read(bytes.NewReader(data[offset:int(offset)+size]), furnace.TargetByteOrder, value)
+}
+
+// writeStructAt writes value over data at offset, value has to fit
+func writeStructAt(data []byte, offset uint32, value interface{}) error {
+	size := binary.Size(value)
+	if size < 0 {
+		return errors.New("cannot write " + fmt.Sprintf("%T", value))
+	}
+	if uint64(offset)+uint64(size) > uint64(len(data)) {
+		return errors.New(fmt.Sprint(size) + " bytes at offset " + fmt.Sprint(offset) + " go past the end of the data")
+	}
+	return binary.Write(utils.NewInPlaceWriter(data, int(offset)), furnace.TargetByteOrder, value)
+}
+
 func ReadMXMD(reader io.Reader) (MXMD, error) {
 	mxmd := bytes.NewBuffer(make(MXMD, 0))
 	if _, err := io.Copy(mxmd, reader); err != nil {
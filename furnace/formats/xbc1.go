@@ -7,24 +7,99 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 
 	"github.com/3096/furnace/furnace"
+	"github.com/klauspost/compress/zstd"
 )
 
 var XBC1_MAGIC = [4]byte{'x', 'b', 'c', '1'}
 
 type XBC1Header struct {
 	Magic            [4]byte
-	NumFiles         uint32
+	CompressionType  XBC1CompressionType
 	UncompressedSize uint32
 	CompressedSize   uint32
-	Hash             uint32
-	Name             [0x1C]byte
+	// Hash of the file, it's not known how the games compute it. Files compressed here leave it 0, files that aren't
+	// compressed again keep theirs.
+	Hash uint32
+	Name [0x1C]byte
 }
 
 type XBC1 []byte
 
+// XBC1CompressionType is the field once read as a file count: every xbc1 wraps exactly one file, and the field tells
+// how its data is compressed
+type XBC1CompressionType uint32
+
+const (
+	XBC1_COMPRESSION_TYPE_ZLIB XBC1CompressionType = 1
+	// used by the newer games, like Xenoblade 3 and Xenoblade X Definitive Edition
+	XBC1_COMPRESSION_TYPE_ZSTD XBC1CompressionType = 3
+)
+
+var XBC1CompressionTypeNames = map[XBC1CompressionType]string{
+	XBC1_COMPRESSION_TYPE_ZLIB: "zlib",
+	XBC1_COMPRESSION_TYPE_ZSTD: "zstd",
+}
+
+func (compressionType XBC1CompressionType) String() string {
+	if name, found := XBC1CompressionTypeNames[compressionType]; found {
+		return name
+	}
+	return "unknown(" + fmt.Sprint(uint32(compressionType)) + ")"
+}
+
+func (header *XBC1Header) GetName() string {
+	return string(bytes.TrimRight(header.Name[:], "\x00"))
+}
+
+const XBC1_NAME_OFFSET = 0x14
+
+// SetName renames the file without recompressing it
+func (xbc1 XBC1) SetName(name [0x1C]byte) {
+	copy(xbc1[XBC1_NAME_OFFSET:XBC1_NAME_OFFSET+len(name)], name[:])
+}
+
+// XBC1CompressionLevel goes from XBC1_COMPRESSION_LEVEL_FAST to XBC1_COMPRESSION_LEVEL_BEST, the zero value picks the
+// default level
+type XBC1CompressionLevel int
+
+const (
+	XBC1_COMPRESSION_LEVEL_DEFAULT XBC1CompressionLevel = 0
+	XBC1_COMPRESSION_LEVEL_FAST    XBC1CompressionLevel = 1
+	XBC1_COMPRESSION_LEVEL_BEST    XBC1CompressionLevel = 9
+)
+
+var XBC1CompressionLevelNames = map[XBC1CompressionLevel]string{
+	XBC1_COMPRESSION_LEVEL_DEFAULT: "default",
+	XBC1_COMPRESSION_LEVEL_FAST:    "fast",
+	XBC1_COMPRESSION_LEVEL_BEST:    "best",
+}
+
+func (level XBC1CompressionLevel) String() string {
+	if name, found := XBC1CompressionLevelNames[level]; found {
+		return name
+	}
+	return fmt.Sprint(int(level))
+}
+
+// files have always been compressed as small as possible, it stays the default
 const XBC1_ZLIB_COMPRESSION_LEVEL = zlib.BestCompression
+const XBC1_ZSTD_COMPRESSION_LEVEL = zstd.SpeedBestCompression
+
+// getZstdLevel maps the zlib style levels onto the four zstd encoder levels
+func getZstdLevel(level XBC1CompressionLevel) zstd.EncoderLevel {
+	switch {
+	case level == XBC1_COMPRESSION_LEVEL_DEFAULT || level == XBC1_COMPRESSION_LEVEL_BEST:
+		return XBC1_ZSTD_COMPRESSION_LEVEL
+	case level <= 2:
+		return zstd.SpeedFastest
+	case level <= 5:
+		return zstd.SpeedDefault
+	}
+	return zstd.SpeedBetterCompression
+}
 
 func ReadXBC1Header(reader io.Reader) (XBC1Header, error) {
 	var header XBC1Header
@@ -37,46 +112,222 @@ func ReadXBC1Header(reader io.Reader) (XBC1Header, error) {
 	return header, nil
 }
 
-func ExtractXBC1(reader io.Reader) (XBC1Header, []byte, error) {
+// xbc1Reader decompresses on the fly and checks the size from the header once the data ends
+type xbc1Reader struct {
+	header       XBC1Header
+	decompressor io.ReadCloser
+	readSize     uint64
+}
+
+func (reader *xbc1Reader) Read(p []byte) (int, error) {
+	n, err := reader.decompressor.Read(p)
+	reader.readSize += uint64(n)
+	if reader.readSize > uint64(reader.header.UncompressedSize) {
+		return n, errors.New("Unexpected xbc1 uncompressed size: more than the " + fmt.Sprint(reader.header.UncompressedSize) +
+			" bytes the header says")
+	}
+	if err == io.EOF && reader.readSize != uint64(reader.header.UncompressedSize) {
+		return n, errors.New("Unexpected xbc1 uncompressed size: " + fmt.Sprint(reader.readSize) +
+			", header says " + fmt.Sprint(reader.header.UncompressedSize))
+	}
+	if err != nil && err != io.EOF {
+		return n, errors.New("Error reading " + reader.header.CompressionType.String() + " data: " + err.Error())
+	}
+	return n, err
+}
+
+func (reader *xbc1Reader) Close() error {
+	return reader.decompressor.Close()
+}
+
+// NewXBC1Reader reads the header and returns a reader decompressing the data as it's read, so only what is read gets
+// decompressed. The reader doesn't go past the compressed data of the xbc1.
+func NewXBC1Reader(reader io.Reader) (XBC1Header, io.ReadCloser, error) {
 	header, err := ReadXBC1Header(reader)
 	if err != nil {
 		return XBC1Header{}, nil, err
 	}
-	if header.NumFiles != 1 {
-		return XBC1Header{}, nil, errors.New("Unexpected number of files in xbc1: " + fmt.Sprint(header.NumFiles))
+	decompressor, err := newXBC1Decompressor(header.CompressionType, io.LimitReader(reader, int64(header.CompressedSize)))
+	if err != nil {
+		return XBC1Header{}, nil, err
+	}
+	return header, &xbc1Reader{header: header, decompressor: decompressor}, nil
+}
+
+func newXBC1Decompressor(compressionType XBC1CompressionType, reader io.Reader) (io.ReadCloser, error) {
+	switch compressionType {
+	case XBC1_COMPRESSION_TYPE_ZLIB:
+		zlibReader, err := zlib.NewReader(reader)
+		if err != nil {
+			return nil, errors.New("Error creating zlib reader: " + err.Error())
+		}
+		return zlibReader, nil
+	case XBC1_COMPRESSION_TYPE_ZSTD:
+		zstdReader, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, errors.New("Error creating zstd reader: " + err.Error())
+		}
+		return zstdReader.IOReadCloser(), nil
+	}
+	return nil, errors.New("Unsupported xbc1 compression type: " + compressionType.String())
+}
+
+func ExtractXBC1(reader io.Reader) (XBC1Header, []byte, error) {
+	header, xbc1Reader, err := NewXBC1Reader(reader)
+	if err != nil {
+		return XBC1Header{}, nil, err
 	}
+	defer xbc1Reader.Close()
 
 	uncompressedDataBuffer := bytes.NewBuffer(make([]byte, 0, header.UncompressedSize))
-	zlibReader, err := zlib.NewReader(reader)
-	defer zlibReader.Close()
+	if _, err := io.Copy(uncompressedDataBuffer, xbc1Reader); err != nil {
+		return XBC1Header{}, nil, err
+	}
+	return header, uncompressedDataBuffer.Bytes(), nil
+}
+
+// XBC1Writer compresses data as it's written. The header is written first with empty sizes, Close fills them in.
+type XBC1Writer struct {
+	writer           io.WriteSeeker
+	headerOffset     int64
+	header           XBC1Header
+	compressor       io.WriteCloser
+	compressedWriter *countingWriter
+}
+
+type countingWriter struct {
+	writer io.Writer
+	size   uint64
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	n, err := writer.writer.Write(p)
+	writer.size += uint64(n)
+	return n, err
+}
+
+func NewXBC1Writer(writer io.WriteSeeker, compressionType XBC1CompressionType, level XBC1CompressionLevel, name [0x1C]byte) (*XBC1Writer, error) {
+	headerOffset, err := writer.Seek(0, io.SeekCurrent)
 	if err != nil {
-		return XBC1Header{}, nil, errors.New("Error creating zlib reader: " + err.Error())
+		return nil, errors.New("Error getting xbc1 header offset: " + err.Error())
+	}
+	header := XBC1Header{
+		Magic:           XBC1_MAGIC,
+		CompressionType: compressionType,
+		Name:            name,
 	}
-	if _, err := io.Copy(uncompressedDataBuffer, zlibReader); err != nil {
-		return XBC1Header{}, nil, errors.New("Error reading zlib data: " + err.Error())
+	if err := binary.Write(writer, furnace.TargetByteOrder, &header); err != nil {
+		return nil, errors.New("Error writing xbc1 header: " + err.Error())
 	}
+	compressedWriter := &countingWriter{writer: writer}
+	compressor, err := newXBC1Compressor(compressionType, level, compressedWriter)
+	if err != nil {
+		return nil, err
+	}
+	return &XBC1Writer{
+		writer:           writer,
+		headerOffset:     headerOffset,
+		header:           header,
+		compressor:       compressor,
+		compressedWriter: compressedWriter,
+	}, nil
+}
 
-	return header, uncompressedDataBuffer.Bytes(), nil
+func (writer *XBC1Writer) Write(p []byte) (int, error) {
+	if uint64(writer.header.UncompressedSize)+uint64(len(p)) > math.MaxUint32 {
+		return 0, errors.New("xbc1 data exceeds 4GB")
+	}
+	n, err := writer.compressor.Write(p)
+	writer.header.UncompressedSize += uint32(n)
+	if err != nil {
+		return n, errors.New("Error writing " + writer.header.CompressionType.String() + " data: " + err.Error())
+	}
+	return n, nil
+}
+
+// Close finishes the compressed data and fills in the sizes in the header. The underlying writer is left at the end
+// of the compressed data.
+func (writer *XBC1Writer) Close() error {
+	if err := writer.compressor.Close(); err != nil {
+		return errors.New("Error closing " + writer.header.CompressionType.String() + " writer: " + err.Error())
+	}
+	if writer.compressedWriter.size > math.MaxUint32 {
+		return errors.New("xbc1 compressed data exceeds 4GB")
+	}
+	writer.header.CompressedSize = uint32(writer.compressedWriter.size)
+
+	endOffset, err := writer.writer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.New("Error getting xbc1 end offset: " + err.Error())
+	}
+	if _, err := writer.writer.Seek(writer.headerOffset, io.SeekStart); err != nil {
+		return errors.New("Error seeking to xbc1 header: " + err.Error())
+	}
+	if err := binary.Write(writer.writer, furnace.TargetByteOrder, &writer.header); err != nil {
+		return errors.New("Error writing xbc1 header: " + err.Error())
+	}
+	if _, err := writer.writer.Seek(endOffset, io.SeekStart); err != nil {
+		return errors.New("Error seeking to xbc1 end: " + err.Error())
+	}
+	return nil
+}
+
+func (writer *XBC1Writer) Header() XBC1Header {
+	return writer.header
+}
+
+func newXBC1Compressor(compressionType XBC1CompressionType, level XBC1CompressionLevel, writer io.Writer) (io.WriteCloser, error) {
+	if level != XBC1_COMPRESSION_LEVEL_DEFAULT && (level < XBC1_COMPRESSION_LEVEL_FAST || level > XBC1_COMPRESSION_LEVEL_BEST) {
+		return nil, errors.New("Invalid xbc1 compression level: " + level.String())
+	}
+	switch compressionType {
+	case XBC1_COMPRESSION_TYPE_ZLIB:
+		zlibLevel := XBC1_ZLIB_COMPRESSION_LEVEL
+		if level != XBC1_COMPRESSION_LEVEL_DEFAULT {
+			zlibLevel = int(level)
+		}
+		zlibWriter, err := zlib.NewWriterLevel(writer, zlibLevel)
+		if err != nil {
+			return nil, errors.New("Error creating zlib writer: " + err.Error())
+		}
+		return zlibWriter, nil
+	case XBC1_COMPRESSION_TYPE_ZSTD:
+		// an empty file still gets a frame, so the data is never empty
+		zstdWriter, err := zstd.NewWriter(writer, zstd.WithEncoderLevel(getZstdLevel(level)), zstd.WithZeroFrames(true))
+		if err != nil {
+			return nil, errors.New("Error creating zstd writer: " + err.Error())
+		}
+		return zstdWriter, nil
+	}
+	return nil, errors.New("Unsupported xbc1 compression type: " + compressionType.String())
 }
 
 func CompressToXBC1(name [0x1C]byte, data []byte) (XBC1, error) {
+	return CompressToXBC1WithType(XBC1_COMPRESSION_TYPE_ZLIB, name, data)
+}
+
+func CompressToXBC1WithType(compressionType XBC1CompressionType, name [0x1C]byte, data []byte) (XBC1, error) {
+	return CompressToXBC1WithLevel(compressionType, XBC1_COMPRESSION_LEVEL_DEFAULT, name, data)
+}
+
+func CompressToXBC1WithLevel(compressionType XBC1CompressionType, level XBC1CompressionLevel, name [0x1C]byte, data []byte) (XBC1, error) {
 	header := XBC1Header{
 		Magic:            XBC1_MAGIC,
-		NumFiles:         1,
+		CompressionType:  compressionType,
 		UncompressedSize: uint32(len(data)),
 		Name:             name,
 	}
 
 	compressedDataBuffer := bytes.Buffer{}
-	zlibWriter, err := zlib.NewWriterLevel(&compressedDataBuffer, XBC1_ZLIB_COMPRESSION_LEVEL)
+	compressor, err := newXBC1Compressor(compressionType, level, &compressedDataBuffer)
 	if err != nil {
-		return nil, errors.New("Error creating zlib writer: " + err.Error())
+		return nil, err
 	}
-	if _, err := zlibWriter.Write(data); err != nil {
-		return nil, errors.New("Error writing zlib data: " + err.Error())
+	if _, err := compressor.Write(data); err != nil {
+		return nil, errors.New("Error writing " + compressionType.String() + " data: " + err.Error())
 	}
-	if err := zlibWriter.Close(); err != nil {
-		return nil, errors.New("Error closing zlib writer: " + err.Error())
+	if err := compressor.Close(); err != nil {
+		return nil, errors.New("Error closing " + compressionType.String() + " writer: " + err.Error())
 	}
 
 	xbc1Buffer := bytes.Buffer{}
@@ -1,6 +1,9 @@
 package furnace
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/3096/furnace/dds"
 	"github.com/3096/furnace/utils"
 )
@@ -35,3 +38,51 @@ func GetSwizzled(data []byte, width, height uint32, format dds.DXGIFormat) []byt
 
 	return swizzled
 }
+
+// GetSwizzledSize returns the size of the surface GetSwizzled produces, the same as the linear data
+func GetSwizzledSize(width, height uint32, format dds.DXGIFormat) uint32 {
+	formatInfo := dds.DXGI_FORMAT_INFO_MAP[format]
+	return utils.Align(width, formatInfo.BlockSideLen) * utils.Align(height, formatInfo.BlockSideLen) * formatInfo.BitsPerPixel / 8
+}
+
+// GetDeswizzled is the inverse of GetSwizzled, data may be longer than the surface.
+// returns an error if data is too short or the surface blocks would overlap, GetSwizzled can't lay those out either
+func GetDeswizzled(data []byte, width, height uint32, format dds.DXGIFormat) ([]byte, error) {
+	formatInfo, found := dds.DXGI_FORMAT_INFO_MAP[format]
+	if !found {
+		return nil, errors.New("Unsupported swizzle format: " + fmt.Sprint(format))
+	}
+	bytesPerBlock := formatInfo.BitsPerPixel * formatInfo.BlockSideLen * formatInfo.BlockSideLen / 8
+	widthBlocks := utils.Align(width, formatInfo.BlockSideLen) / formatInfo.BlockSideLen
+	heightBlocks := utils.Align(height, formatInfo.BlockSideLen) / formatInfo.BlockSideLen
+	xBitsShift := 3
+	for i := uint32(0); i < 4; i++ {
+		if ((heightBlocks - 1) & (8 << i)) != 0 {
+			xBitsShift += 1
+		}
+	}
+
+	deswizzled := make([]byte, widthBlocks*heightBlocks*bytesPerBlock)
+	blockRead := make([]bool, len(data)/int(bytesPerBlock))
+	curOffset := uint32(0)
+	for y := uint32(0); y < heightBlocks; y++ {
+		for x := uint32(0); x < widthBlocks; x++ {
+			xRaw := x * bytesPerBlock
+			swizzledOffset := ((y & 0xff80) * widthBlocks * bytesPerBlock) | ((y & 0x78) << 6) | ((y & 6) << 5) | ((y & 1) << 4) |
+				((xRaw & 0xffc0) << xBitsShift) | ((xRaw & 0x20) << 3) | ((xRaw & 0x10) << 1) | (xRaw & 0xf)
+			if int(swizzledOffset+bytesPerBlock) > len(data) {
+				return nil, errors.New("Swizzled data too short: " + fmt.Sprintf("%dx%d %s needs more than %d bytes", width,
+					height, formatInfo.Name, len(data)))
+			}
+			if blockRead[swizzledOffset/bytesPerBlock] {
+				return nil, errors.New("Unsupported swizzled surface size: " + fmt.Sprintf("%dx%d %s", width, height,
+					formatInfo.Name))
+			}
+			blockRead[swizzledOffset/bytesPerBlock] = true
+			copy(deswizzled[curOffset:curOffset+bytesPerBlock], data[swizzledOffset:swizzledOffset+bytesPerBlock])
+			curOffset += bytesPerBlock
+		}
+	}
+
+	return deswizzled, nil
+}
@@ -1,3 +1,5 @@
 module github.com/3096/furnace
 
 go 1.18
+
+require github.com/klauspost/compress v1.15.15
@@ -1,20 +1,11 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
-	"github.com/3096/furnace/commands"
+	"github.com/3096/furnace/cli"
 )
 
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Println("Usage: " + os.Args[0] + " <in wismt> <texture dir> <out wismt>")
-		os.Exit(1)
-	}
-	err := commands.ReplaceTexturesInWismt(os.Args[1], os.Args[2], os.Args[3])
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
+	os.Exit(cli.Run(os.Args[0], os.Args[1:]))
 }
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/3096/furnace/cli"
+)
+
+func TestCLIUsage(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		exitCode int
+	}{
+		{[]string{}, cli.EXIT_USAGE},
+		{[]string{"help"}, cli.EXIT_OK},
+		{[]string{"help", "replace"}, cli.EXIT_OK},
+		{[]string{"help", "unknown"}, cli.EXIT_USAGE},
+		{[]string{"unknown"}, cli.EXIT_USAGE},
+		{[]string{"extrct", "formats_testdata/wismt/pc079404.wismt", "commands_testdata/test-out/cli-typo"}, cli.EXIT_USAGE},
+		{[]string{"formats_testdata/wismt/missing.wismt", "commands_testdata/msrd-replaced-textures",
+			"commands_testdata/test-out/cli-legacy-missing/pc079404.wismt"}, cli.EXIT_USAGE},
+		{[]string{"replace", "-h"}, cli.EXIT_OK},
+		{[]string{"replace", "-unknown-flag"}, cli.EXIT_USAGE},
+		{[]string{"replace", "formats_testdata/wismt/pc079404.wismt"}, cli.EXIT_USAGE},
+		{[]string{"extract", "formats_testdata/wismt/missing.wismt", "commands_testdata/test-out/cli-missing"}, cli.EXIT_ERROR},
+	}
+
+	for _, testCase := range testCases {
+		if exitCode := cli.Run("furnace", testCase.args); exitCode != testCase.exitCode {
+			t.Errorf("Expected exit code %d for %v, got %d", testCase.exitCode, testCase.args, exitCode)
+		}
+	}
+}
+
+func TestCLIReplaceDryRun(t *testing.T) {
+	wismtOutFilePath := "commands_testdata/test-out/cli-dry-run/pc079404.wismt"
+
+	exitCode := cli.Run("furnace", []string{"replace", "-dry-run",
+		"formats_testdata/wismt/pc079404.wismt", "commands_testdata/msrd-replaced-textures", wismtOutFilePath})
+	if exitCode != cli.EXIT_OK {
+		t.Fatalf("Expected exit code %d, got %d", cli.EXIT_OK, exitCode)
+	}
+
+	if _, err := os.Stat(wismtOutFilePath); !os.IsNotExist(err) {
+		t.Errorf("Expected %s not to be written in a dry run", wismtOutFilePath)
+	}
+}
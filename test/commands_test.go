@@ -1,9 +1,18 @@
 package test
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/3096/furnace/commands"
+	"github.com/3096/furnace/furnace"
+	"github.com/3096/furnace/furnace/formats"
 	"github.com/3096/furnace/utils"
 )
 
@@ -22,3 +31,809 @@ func TestReplaceTexturesInWismt(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestExtractTexturesFromWismt(t *testing.T) {
+	wismtTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	extractedTexturesDir := "commands_testdata/test-out/extract-textures"
+	wismtOutFilePath := "commands_testdata/test-out/extract-replace-textures/pc079404.wismt"
+
+	err := commands.ExtractTexturesFromWismt(wismtTestFilePath, extractedTexturesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(extractedTexturesDir + "/00.PC079404_WAIST.dds"); err != nil {
+		t.Errorf("Expected extracted textures to be named <id.name.dds>: %s", err)
+	}
+
+	err = utils.EnsureDirectory(wismtOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.ReplaceTexturesInWismt(wismtTestFilePath, extractedTexturesDir, wismtOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origWismt := readTestMSRD(t, wismtTestFilePath)
+	outWismt := readTestMSRD(t, wismtOutFilePath)
+	for i := formats.MSRD_FILE_INDEX_0; i < len(origWismt.CompressedFiles); i++ {
+		_, origData, err := formats.ExtractXBC1(bytes.NewReader(origWismt.CompressedFiles[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, outData, err := formats.ExtractXBC1(bytes.NewReader(outWismt.CompressedFiles[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(origData, outData) {
+			t.Errorf("Expected file %d to be unchanged after extract and replace", i)
+		}
+	}
+}
+
+func TestReplaceTexturesAddNew(t *testing.T) {
+	wismtTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	replacementTexturePath := "commands_testdata/msrd-replaced-textures/00.PC079404_WAIST.dds"
+	newTexturesDir := "commands_testdata/test-out/add-new-textures/in"
+	wismtOutFilePath := "commands_testdata/test-out/add-new-textures/pc079404.wismt"
+	extractedTexturesDir := "commands_testdata/test-out/add-new-textures/out"
+
+	replacementTexture, err := ioutil.ReadFile(replacementTexturePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fileName := range []string{"00.PC079404_WAIST.dds", "PC079404_NEW.dds"} {
+		if err := utils.EnsureDirectory(newTexturesDir + "/" + fileName); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(newTexturesDir+"/"+fileName, replacementTexture, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = commands.ReplaceTexturesInWismtWithOptions(wismtTestFilePath, newTexturesDir, wismtOutFilePath,
+		commands.ReplaceTexturesOptions{AddNewTextures: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origWismt := readTestMSRD(t, wismtTestFilePath)
+	outWismt := readTestMSRD(t, wismtOutFilePath)
+	newTextureIds := outWismt.GetTextureIdsByName("PC079404_NEW")
+	if len(newTextureIds) != 1 || int(newTextureIds[0]) != len(origWismt.TextureNames) ||
+		len(outWismt.CompressedFiles) != len(origWismt.CompressedFiles)+1 {
+		t.Fatalf("Expected PC079404_NEW to be added as a new streamed texture, got %v", outWismt.TextureNames)
+	}
+
+	err = commands.ExtractTexturesFromWismt(wismtOutFilePath, extractedTexturesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replacedTexture, err := ioutil.ReadFile(extractedTexturesDir + "/00.PC079404_WAIST.dds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTexture, err := ioutil.ReadFile(fmt.Sprintf("%s/%02d.PC079404_NEW.dds", extractedTexturesDir, newTextureIds[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(replacedTexture, newTexture) {
+		t.Errorf("Expected the new texture to extract the same as the replaced one")
+	}
+}
+
+func TestReplaceTexturesPromoteCacheOnly(t *testing.T) {
+	wismtTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	wimdoTestFilePath := "formats_testdata/wismt/pc079404.wimdo"
+	origTexturesDir := "commands_testdata/test-out/promote-textures/orig"
+	cacheOnlyWismtPath := "commands_testdata/test-out/promote-textures/cache-only/pc079404.wismt"
+	wismtOutFilePath := "commands_testdata/test-out/promote-textures/pc079404.wismt"
+	extractedTexturesDir := "commands_testdata/test-out/promote-textures/out"
+	textureFileName := "04.PC079404_WAIST_MTL.dds"
+
+	err := commands.ExtractTexturesFromWismt(wismtTestFilePath, origTexturesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// drop the stream entry of the last texture so only its cached MIBL is left
+	wismt := readTestMSRD(t, wismtTestFilePath)
+	origMetaData := wismt.MetaData
+	splitMips, err := wismt.GetSplitMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(wismt.TextureIdToIndexMap, 4)
+	wismt.DataItems = wismt.DataItems[:len(wismt.DataItems)-1]
+	wismt.CompressedFiles = wismt.CompressedFiles[:len(wismt.CompressedFiles)-1]
+	wismt.MetaHeader.TextureIdsCount--
+	wismt.MetaHeader.TextureDataItemsCount--
+	wismt.MetaHeader.DataItemsCount--
+	wismt.MetaHeader.FileCount--
+	if err := wismt.SetMips(splitMips[:len(splitMips)-1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := wismt.UpdateMetaData(); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.EnsureDirectory(cacheOnlyWismtPath); err != nil {
+		t.Fatal(err)
+	}
+	cacheOnlyWismtFile, err := os.Create(cacheOnlyWismtPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = formats.WriteMSRD(cacheOnlyWismtFile, wismt)
+	cacheOnlyWismtFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wimdoFile, err := ioutil.ReadFile(wimdoTestFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wimdo := formats.MXMD(wimdoFile)
+	if err := wimdo.SetUncachedTextures(origMetaData, wismt.MetaData); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(commands.GetWimdoPath(cacheOnlyWismtPath), wimdo, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.ReplaceTexturesInWismt(cacheOnlyWismtPath, origTexturesDir, wismtOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outWismt := readTestMSRD(t, wismtOutFilePath)
+	if index, found := outWismt.TextureIdToIndexMap[4]; !found || index != 4 ||
+		len(outWismt.CompressedFiles) != formats.MSRD_FILE_INDEX_TEXTURE_START+5 {
+		t.Fatalf("Expected texture 4 to be streamed again, got %v", outWismt.TextureIdToIndexMap)
+	}
+
+	err = commands.ExtractTexturesFromWismt(wismtOutFilePath, extractedTexturesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origTexture, err := ioutil.ReadFile(origTexturesDir + "/" + textureFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	promotedTexture, err := ioutil.ReadFile(extractedTexturesDir + "/" + textureFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(origTexture, promotedTexture) {
+		t.Errorf("Expected the promoted texture to keep its full resolution")
+	}
+}
+
+func TestReplaceTexturesCompressionLevel(t *testing.T) {
+	wismtTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	texturesDir := "commands_testdata/msrd-replaced-textures"
+	wismtOutFilePath := "commands_testdata/test-out/compression-level/pc079404.wismt"
+
+	if err := utils.EnsureDirectory(wismtOutFilePath); err != nil {
+		t.Fatal(err)
+	}
+	err := commands.ReplaceTexturesInWismtWithOptions(wismtTestFilePath, texturesDir, wismtOutFilePath,
+		commands.ReplaceTexturesOptions{CompressionLevel: formats.XBC1_COMPRESSION_LEVEL_FAST, CompareCompressionLevels: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origWismt := readTestMSRD(t, wismtTestFilePath)
+	outWismt := readTestMSRD(t, wismtOutFilePath)
+	for i, compressedFile := range outWismt.CompressedFiles {
+		_, data, err := formats.ExtractXBC1(bytes.NewReader(compressedFile))
+		if err != nil {
+			t.Fatalf("Expected file %d compressed at the fast level to extract, got %s", i, err)
+		}
+		_, origData, err := formats.ExtractXBC1(bytes.NewReader(origWismt.CompressedFiles[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(data, origData) && !bytes.Equal(compressedFile, origWismt.CompressedFiles[i]) {
+			t.Errorf("Expected unchanged file %d to be kept as is", i)
+		}
+	}
+
+	for name, expectedLevel := range map[string]formats.XBC1CompressionLevel{
+		"fast":    formats.XBC1_COMPRESSION_LEVEL_FAST,
+		"default": formats.XBC1_COMPRESSION_LEVEL_DEFAULT,
+		"best":    formats.XBC1_COMPRESSION_LEVEL_BEST,
+		"6":       formats.XBC1CompressionLevel(6),
+	} {
+		level, err := commands.ParseXBC1CompressionLevel(name)
+		if err != nil || level != expectedLevel {
+			t.Errorf("Expected level %s to parse as %d, got %d, %v", name, expectedLevel, level, err)
+		}
+	}
+	for _, name := range []string{"0", "10", "zstd"} {
+		if _, err := commands.ParseXBC1CompressionLevel(name); err == nil {
+			t.Errorf("Expected level %s to be rejected", name)
+		}
+	}
+}
+
+func TestReplaceTexturesZstdWismt(t *testing.T) {
+	wismtTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	wimdoTestFilePath := "formats_testdata/wismt/pc079404.wimdo"
+	replacementTexturesDir := "commands_testdata/msrd-replaced-textures"
+	zstdWismtPath := "commands_testdata/test-out/zstd-wismt/zstd/pc079404.wismt"
+	zlibOutFilePath := "commands_testdata/test-out/zstd-wismt/zlib-out/pc079404.wismt"
+	zstdOutFilePath := "commands_testdata/test-out/zstd-wismt/zstd-out/pc079404.wismt"
+
+	// the same wismt with every file compressed with zstd, like the newer games have them
+	wismt := readTestMSRD(t, wismtTestFilePath)
+	origMetaData := wismt.MetaData
+	for i, compressedFile := range wismt.CompressedFiles {
+		header, data, err := formats.ExtractXBC1(bytes.NewReader(compressedFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zstdFile, err := formats.CompressToXBC1WithType(formats.XBC1_COMPRESSION_TYPE_ZSTD, header.Name, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wismt.SetCompressedFileData(i, zstdFile)
+	}
+	if err := wismt.UpdateMetaData(); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.EnsureDirectory(zstdWismtPath); err != nil {
+		t.Fatal(err)
+	}
+	zstdWismtFile, err := os.Create(zstdWismtPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = formats.WriteMSRD(zstdWismtFile, wismt)
+	zstdWismtFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wimdoFile, err := ioutil.ReadFile(wimdoTestFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wimdo := formats.MXMD(wimdoFile)
+	if err := wimdo.SetUncachedTextures(origMetaData, wismt.MetaData); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(commands.GetWimdoPath(zstdWismtPath), wimdo, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, paths := range [][2]string{{wismtTestFilePath, zlibOutFilePath}, {zstdWismtPath, zstdOutFilePath}} {
+		if err := utils.EnsureDirectory(paths[1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := commands.ReplaceTexturesInWismt(paths[0], replacementTexturesDir, paths[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	zlibOutWismt := readTestMSRD(t, zlibOutFilePath)
+	zstdOutWismt := readTestMSRD(t, zstdOutFilePath)
+	if len(zstdOutWismt.CompressedFiles) != len(zlibOutWismt.CompressedFiles) {
+		t.Fatalf("Expected %d files, got %d", len(zlibOutWismt.CompressedFiles), len(zstdOutWismt.CompressedFiles))
+	}
+	for i, compressedFile := range zstdOutWismt.CompressedFiles {
+		header, data, err := formats.ExtractXBC1(bytes.NewReader(compressedFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.CompressionType != formats.XBC1_COMPRESSION_TYPE_ZSTD {
+			t.Errorf("Expected file %d to stay zstd compressed, got %s", i, header.CompressionType)
+		}
+		_, zlibData, err := formats.ExtractXBC1(bytes.NewReader(zlibOutWismt.CompressedFiles[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, zlibData) {
+			t.Errorf("Expected file %d to have the same content as when replaced in the zlib wismt", i)
+		}
+	}
+}
+
+func TestReplaceTexturesMismatchedWimdo(t *testing.T) {
+	wismtTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	wimdoTestFilePath := "formats_testdata/wismt/pc079404.wimdo"
+	mismatchedWimdoPath := "commands_testdata/test-out/mismatched-wimdo/pc079404.wimdo"
+	wismtOutFilePath := "commands_testdata/test-out/mismatched-wimdo/out/pc079404.wismt"
+	replacementTexturesDir := "commands_testdata/msrd-replaced-textures"
+
+	wimdoFile, err := ioutil.ReadFile(wimdoTestFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wimdo := formats.MXMD(wimdoFile)
+	tables, err := wimdo.GetUncachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables.TextureInfoItems[0].CacheSize++
+	wimdoHeader, err := wimdo.GetHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tables.Write(formats.MSRDMetaData(wimdo[wimdoHeader.UncachedTexturesOffset:])); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.EnsureDirectory(wismtOutFilePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(mismatchedWimdoPath, wimdo, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.ReplaceTexturesInWismtWithOptions(wismtTestFilePath, replacementTexturesDir, wismtOutFilePath,
+		commands.ReplaceTexturesOptions{InWimdoPath: mismatchedWimdoPath})
+	if err == nil || !strings.Contains(err.Error(), "texture info items differ") {
+		t.Errorf("Expected the mismatched wimdo to be rejected, got %v", err)
+	}
+	if _, err := os.Stat(wismtOutFilePath); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be saved for a mismatched wimdo")
+	}
+}
+
+func TestUnpackPackWismt(t *testing.T) {
+	wismtTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	unpackedDir := "commands_testdata/test-out/unpack/pc079404"
+	wismtOutFilePath := "commands_testdata/test-out/unpack/pc079404.wismt"
+	changedWismtOutFilePath := "commands_testdata/test-out/unpack/changed/pc079404.wismt"
+
+	err := commands.UnpackWismt(wismtTestFilePath, unpackedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.PackWismt(unpackedDir, wismtOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origWismtFile, err := ioutil.ReadFile(wismtTestFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outWismtFile, err := ioutil.ReadFile(wismtOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(origWismtFile, outWismtFile) {
+		t.Errorf("Expected packing an unchanged directory to give back the original wismt")
+	}
+
+	changedFilePath := unpackedDir + "/files/0002.bin"
+	changedFile, err := ioutil.ReadFile(changedFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changedFile[0] ^= 0xFF
+	if err := ioutil.WriteFile(changedFilePath, changedFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.EnsureDirectory(changedWismtOutFilePath); err != nil {
+		t.Fatal(err)
+	}
+	err = commands.PackWismt(unpackedDir, changedWismtOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origWismt := readTestMSRD(t, wismtTestFilePath)
+	changedWismt := readTestMSRD(t, changedWismtOutFilePath)
+	for i := range origWismt.CompressedFiles {
+		if i == 2 {
+			header, data, err := formats.ExtractXBC1(bytes.NewReader(changedWismt.CompressedFiles[i]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, changedFile) || header.GetName() != "0002" {
+				t.Errorf("Expected file 2 to be packed with its changed content")
+			}
+		} else if !bytes.Equal(origWismt.CompressedFiles[i], changedWismt.CompressedFiles[i]) {
+			t.Errorf("Expected unchanged file %d to keep its original compressed data", i)
+		}
+	}
+
+	// without the original xbc1 copies, every file is compressed again from its content
+	if err := os.RemoveAll(unpackedDir + "/" + commands.UNPACKED_XBC1_DIR); err != nil {
+		t.Fatal(err)
+	}
+	noXBC1WismtOutFilePath := "commands_testdata/test-out/unpack/no-xbc1/pc079404.wismt"
+	if err := utils.EnsureDirectory(noXBC1WismtOutFilePath); err != nil {
+		t.Fatal(err)
+	}
+	err = commands.PackWismt(unpackedDir, noXBC1WismtOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noXBC1Wismt := readTestMSRD(t, noXBC1WismtOutFilePath)
+	if len(noXBC1Wismt.CompressedFiles) != len(changedWismt.CompressedFiles) {
+		t.Fatalf("Expected %d files, got %d", len(changedWismt.CompressedFiles), len(noXBC1Wismt.CompressedFiles))
+	}
+	for i := range changedWismt.CompressedFiles {
+		changedHeader, changedData, err := formats.ExtractXBC1(bytes.NewReader(changedWismt.CompressedFiles[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, data, err := formats.ExtractXBC1(bytes.NewReader(noXBC1Wismt.CompressedFiles[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, changedData) || header.GetName() != changedHeader.GetName() {
+			t.Errorf("Expected file %d to be packed with the same content without its xbc1 copy", i)
+		}
+	}
+}
+
+func TestReplaceDataItemInWismt(t *testing.T) {
+	wismtTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	modelFilePath := "commands_testdata/test-out/data-items/model.bin"
+	wismtOutFilePath := "commands_testdata/test-out/data-items/pc079404.wismt"
+
+	err := utils.EnsureDirectory(modelFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = commands.ExtractDataItemFromWismt(wismtTestFilePath, "model", modelFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := ioutil.ReadFile(modelFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model = append(model, bytes.Repeat([]byte{0xCD}, 100)...)
+	if err := ioutil.WriteFile(modelFilePath, model, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = commands.ReplaceDataItemInWismt(wismtTestFilePath, "0", modelFilePath, wismtOutFilePath, commands.ReplaceDataItemOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outWismt := readTestMSRD(t, wismtOutFilePath)
+	if modelIndex := findTestDataItem(t, &outWismt, formats.MSRD_DATA_ITEM_TYPE_MODEL); modelIndex != 0 {
+		t.Fatalf("Expected data item 0 to be the model, got %d", modelIndex)
+	}
+	outModel, err := outWismt.GetDataItemData(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(outModel, model) {
+		t.Errorf("Expected the model data item to be replaced")
+	}
+	origWismt := readTestMSRD(t, wismtTestFilePath)
+	origCachedTextures, err := origWismt.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outCachedTextures, err := outWismt.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range origCachedTextures {
+		if !bytes.Equal(origCachedTextures[i], outCachedTextures[i]) {
+			t.Errorf("Expected cached texture %d to be preserved", i)
+		}
+	}
+
+	wimdoFile, err := ioutil.ReadFile(commands.GetWimdoPath(wismtOutFilePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wimdo := formats.MXMD(wimdoFile)
+	wimdoHeader, err := wimdo.GetHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wimdo[wimdoHeader.UncachedTexturesOffset:wimdoHeader.UncachedTexturesOffset+outWismt.Header.MetaDataSize], outWismt.MetaData) {
+		t.Errorf("Expected the wimdo to embed the updated metadata")
+	}
+
+	for _, item := range []string{"texture", "unknown", "8", "-1"} {
+		if _, err := commands.ResolveDataItem(&outWismt, item); err == nil {
+			t.Errorf("Expected data item %s not to resolve", item)
+		}
+	}
+	// items named by the metadata header are found through it
+	outWismt.DataItems[0], outWismt.DataItems[1] = outWismt.DataItems[1], outWismt.DataItems[0]
+	outWismt.MetaHeader.ModelDataItemIndex, outWismt.MetaHeader.ShaderBundleDataItemIndex = 1, 0
+	if index, err := commands.ResolveDataItem(&outWismt, "model"); err != nil || index != 1 {
+		t.Errorf("Expected the model to resolve to data item 1, got %d, %v", index, err)
+	}
+	outWismt.MetaHeader.ModelDataItemIndex = 0
+	if _, err := commands.ResolveDataItem(&outWismt, "model"); err == nil {
+		t.Errorf("Expected a model index pointing to the shader bundle not to resolve")
+	}
+}
+
+func TestXBC1CompressDecompress(t *testing.T) {
+	unpackedDir := "commands_testdata/test-out/xbc1/unpacked"
+	rawFilePath := "commands_testdata/test-out/xbc1/0002.bin"
+	compressedFilePath := "commands_testdata/test-out/xbc1/0002.xbc1"
+
+	err := commands.UnpackWismt("formats_testdata/wismt/pc079404.wismt", unpackedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origFilePath := unpackedDir + "/xbc1/0002.xbc1"
+	err = commands.DecompressXBC1(origFilePath, rawFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawFile, err := ioutil.ReadFile(rawFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unpackedFile, err := ioutil.ReadFile(unpackedDir + "/files/0002.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rawFile, unpackedFile) {
+		t.Errorf("Expected the xbc1 to decompress to the unpacked file")
+	}
+
+	for _, options := range []commands.XBC1Options{{NameFromPath: origFilePath}, {Name: "renamed"}, {}} {
+		err = commands.CompressXBC1(rawFilePath, compressedFilePath, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressedFile, err := ioutil.ReadFile(compressedFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, data, err := formats.ExtractXBC1(bytes.NewReader(compressedFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedName := options.Name
+		if options.NameFromPath != "" || options.Name == "" {
+			expectedName = "0002"
+		}
+		if !bytes.Equal(data, rawFile) || header.GetName() != expectedName {
+			t.Errorf("Expected the raw file to be compressed as %s, got %s", expectedName, header.GetName())
+		}
+	}
+
+	// -name-from keeps the compression type of the file too, unless one is given
+	zstdFilePath := "commands_testdata/test-out/xbc1/0002-zstd.xbc1"
+	err = commands.CompressXBC1(rawFilePath, zstdFilePath, commands.XBC1Options{CompressionType: formats.XBC1_COMPRESSION_TYPE_ZSTD})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, compressionType := range []formats.XBC1CompressionType{0, formats.XBC1_COMPRESSION_TYPE_ZLIB} {
+		err = commands.CompressXBC1(rawFilePath, compressedFilePath,
+			commands.XBC1Options{NameFromPath: zstdFilePath, CompressionType: compressionType})
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressedFile, err := ioutil.ReadFile(compressedFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err := formats.ReadXBC1Header(bytes.NewReader(compressedFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedCompressionType := compressionType
+		if compressionType == 0 {
+			expectedCompressionType = formats.XBC1_COMPRESSION_TYPE_ZSTD
+		}
+		if header.CompressionType != expectedCompressionType {
+			t.Errorf("Expected -name-from with type %s to compress with %s, got %s", compressionType,
+				expectedCompressionType, header.CompressionType)
+		}
+	}
+
+	if err := commands.CompressXBC1(rawFilePath, compressedFilePath, commands.XBC1Options{Name: strings.Repeat("a", 0x1D)}); err == nil {
+		t.Errorf("Expected a name longer than 0x1C bytes to be rejected")
+	}
+
+	// failures don't leave partial output behind
+	failedFilePath := "commands_testdata/test-out/xbc1/failed.bin"
+	if err := commands.CompressXBC1(rawFilePath, failedFilePath, commands.XBC1Options{CompressionLevel: 42}); err == nil {
+		t.Errorf("Expected an invalid compression level to be rejected")
+	}
+	if _, err := os.Stat(failedFilePath); !os.IsNotExist(err) {
+		t.Errorf("Expected the output of the failed compression to be removed, got %v", err)
+	}
+	origFile, err := ioutil.ReadFile(origFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncatedFilePath := "commands_testdata/test-out/xbc1/truncated.xbc1"
+	if err := ioutil.WriteFile(truncatedFilePath, origFile[:len(origFile)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := commands.DecompressXBC1(truncatedFilePath, failedFilePath); err == nil {
+		t.Errorf("Expected a truncated xbc1 to be rejected")
+	}
+	if _, err := os.Stat(failedFilePath); !os.IsNotExist(err) {
+		t.Errorf("Expected the output of the failed decompression to be removed, got %v", err)
+	}
+}
+
+func readTestMSRD(t *testing.T, msrdPath string) formats.MSRD {
+	msrdFile, err := os.Open(msrdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer msrdFile.Close()
+	msrd, err := formats.ReadMSRD(msrdFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msrd
+}
+
+func findTestDataItem(t *testing.T, msrd *formats.MSRD, dataItemType formats.MSRDDataItemType) int {
+	for i, dataItem := range msrd.DataItems {
+		if dataItem.Type == dataItemType {
+			return i
+		}
+	}
+	t.Fatalf("Expected a %s data item", dataItemType)
+	return -1
+}
+
+func TestPrintWismtInfo(t *testing.T) {
+	wismtTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+
+	infoBuffer := bytes.Buffer{}
+	err := commands.PrintWismtInfo(&infoBuffer, wismtTestFilePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info commands.WismtInfo
+	if err := json.Unmarshal(infoBuffer.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+
+	wismt := readTestMSRD(t, wismtTestFilePath)
+	if len(info.DataItems) != len(wismt.DataItems) || len(info.Files) != len(wismt.CompressedFiles) ||
+		len(info.Textures) != len(wismt.TextureInfoItems) {
+		t.Errorf("Expected info to list every data item, file and texture")
+	}
+	for _, texture := range info.Textures {
+		if texture.CacheFooter == nil || texture.CacheFooter.Magic != formats.MIBL_MAGIC {
+			t.Errorf("Expected a MIBL footer for texture %d", texture.Id)
+		}
+	}
+	if info.WimdoHeader == nil || info.WimdoHeader.Magic != formats.MXMD_MAGIC {
+		t.Errorf("Expected the wimdo header to be included")
+	}
+	if info.Wimdo == nil || info.Wimdo.Materials == nil || len(info.Wimdo.Materials.Materials) != 4 {
+		t.Errorf("Expected the parsed wimdo to be included, got error %q", info.WimdoError)
+	}
+}
+
+func TestValidateWismt(t *testing.T) {
+	wismtTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	wimdoTestFilePath := "formats_testdata/wismt/pc079404.wimdo"
+	replacedWismtPath := "commands_testdata/test-out/validate/replaced/pc079404.wismt"
+	brokenWismtPath := "commands_testdata/test-out/validate/broken/pc079404.wismt"
+	brokenWimdoPath := "commands_testdata/test-out/validate/broken/pc079404-bad-offset.wimdo"
+	replacementTexturesDir := "commands_testdata/msrd-replaced-textures"
+
+	problems, err := commands.ValidateWismt(wismtTestFilePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems in the original wismt, got %v", problems)
+	}
+
+	if err := utils.EnsureDirectory(replacedWismtPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := commands.ReplaceTexturesInWismt(wismtTestFilePath, replacementTexturesDir, replacedWismtPath); err != nil {
+		t.Fatal(err)
+	}
+	problems, err = commands.ValidateWismt(replacedWismtPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems after replacing textures, got %v", problems)
+	}
+
+	// a cached texture with no width, along with a wimdo from before it was saved
+	wismt := readTestMSRD(t, wismtTestFilePath)
+	cachedTextures, err := wismt.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	brokenTexture := append(formats.MIBL{}, cachedTextures[1]...)
+	footerOffset := len(brokenTexture) - binary.Size(formats.MIBLFooter{})
+	furnace.TargetByteOrder.PutUint32(brokenTexture[footerOffset+8:], 0)
+	cachedTextures[1] = brokenTexture
+	if err := wismt.SetCachedTextures(cachedTextures); err != nil {
+		t.Fatal(err)
+	}
+	wismt.TextureNames[0] = "PC079404_WAIST_RENAMED"
+	if err := utils.EnsureDirectory(brokenWismtPath); err != nil {
+		t.Fatal(err)
+	}
+	brokenWismtFile, err := os.Create(brokenWismtPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = formats.WriteMSRD(brokenWismtFile, wismt)
+	brokenWismtFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err = commands.ValidateWismt(brokenWismtPath, wimdoTestFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problemsText := fmt.Sprint(problems)
+	if len(problems) != 2 || !strings.Contains(problemsText, "Cached texture 1") ||
+		!strings.Contains(problemsText, "don't match") {
+		t.Errorf("Expected the broken texture and the mismatched wimdo to be found, got %v", problems)
+	}
+	if err := commands.PrintWismtValidation(ioutil.Discard, brokenWismtPath, wimdoTestFilePath); err == nil {
+		t.Errorf("Expected validation to fail")
+	}
+
+	// a wimdo whose materials offset points past its end
+	wimdo, err := ioutil.ReadFile(wimdoTestFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	furnace.TargetByteOrder.PutUint32(wimdo[12:], uint32(len(wimdo))+0x100)
+	if err := ioutil.WriteFile(brokenWimdoPath, wimdo, 0644); err != nil {
+		t.Fatal(err)
+	}
+	problems, err = commands.ValidateWismt(wismtTestFilePath, brokenWimdoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || !strings.Contains(fmt.Sprint(problems), "Could not parse wimdo") {
+		t.Errorf("Expected the bad materials offset to be found, got %v", problems)
+	}
+}
+
+func TestResolveTextureFileName(t *testing.T) {
+	wismt := readTestMSRD(t, "formats_testdata/wismt/pc079404.wismt")
+
+	testCases := []struct {
+		fileName  string
+		textureId formats.MSRDTextureId
+		valid     bool
+	}{
+		{"00.PC079404_WAIST.dds", 0, true},
+		{"PC079404_WAIST_NRM.dds", 2, true},
+		{"03.PC079404_WAIST_NRM.dds", 2, true},
+		{"04.renamed.dds", 4, true},
+		{"01.dds", 1, true},
+		{"renamed.dds", 0, false},
+		{"05.PC079404_WAIST.dds", 0, false},
+		{"noextension", 0, false},
+	}
+	for _, testCase := range testCases {
+		textureId, err := commands.ResolveTextureFileName(&wismt, testCase.fileName)
+		if testCase.valid && (err != nil || textureId != testCase.textureId) {
+			t.Errorf("Expected %s to resolve to texture %d, got %d (%v)", testCase.fileName, testCase.textureId, textureId, err)
+		}
+		if !testCase.valid && err == nil {
+			t.Errorf("Expected %s not to resolve, got texture %d", testCase.fileName, textureId)
+		}
+	}
+
+	wismt.TextureNames[3] = wismt.TextureNames[2]
+	if _, err := commands.ResolveTextureFileName(&wismt, "PC079404_WAIST_NRM.dds"); err == nil {
+		t.Errorf("Expected ambiguous texture name to be reported")
+	}
+	if textureId, err := commands.ResolveTextureFileName(&wismt, "03.PC079404_WAIST_NRM.dds"); err != nil || textureId != 3 {
+		t.Errorf("Expected id number to pick between textures sharing a name, got %d (%v)", textureId, err)
+	}
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/3096/furnace/dds"
+)
+
+func TestDDSWriteRoundTrip(t *testing.T) {
+	ddsTestFilePaths := []string{
+		"formats_testdata/mibl/03.PC060000_KIZU_ALP.dds",
+		"commands_testdata/msrd-replaced-textures/00.PC079404_WAIST.dds",
+	}
+
+	for _, ddsTestFilePath := range ddsTestFilePaths {
+		ddsTestFile, err := ioutil.ReadFile(ddsTestFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		header, headerDXT10, surfaces, err := dds.LoadDDS(bytes.NewReader(ddsTestFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ddsOutBuffer := bytes.Buffer{}
+		err = dds.WriteDDS(&ddsOutBuffer, header, headerDXT10, surfaces)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(ddsTestFile, ddsOutBuffer.Bytes()) {
+			t.Errorf("Expected written dds to be identical to %s", ddsTestFilePath)
+		}
+	}
+}
+
+func TestDDSWriteFormats(t *testing.T) {
+	width, height := uint32(64), uint32(32)
+	random := rand.New(rand.NewSource(3096))
+
+	for format, formatInfo := range dds.DXGI_FORMAT_INFO_MAP {
+		var mips [][]byte
+		for w, h := width, height; w > 0 || h > 0; w, h = w/2, h/2 {
+			mip := make([]byte, formatInfo.GetSurfaceSize(w, h))
+			random.Read(mip)
+			mips = append(mips, mip)
+		}
+
+		ddsOutBuffer := bytes.Buffer{}
+		err := dds.WriteDDS(&ddsOutBuffer, dds.DDSHeader{Width: width, Height: height}, dds.DDSHeaderDXT10{DxgiFormat: format}, [][][]byte{mips})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		header, headerDXT10, surfaces, err := dds.LoadDDS(&ddsOutBuffer)
+		if err != nil {
+			t.Fatalf("%s: %s", formatInfo.Name, err)
+		}
+		if header.Width != width || header.Height != height || header.MipMapCount != uint32(len(mips)) {
+			t.Errorf("%s: unexpected header %+v", formatInfo.Name, header)
+		}
+		if headerDXT10.DxgiFormat != format {
+			t.Errorf("%s: expected format to be read back, got %d", formatInfo.Name, headerDXT10.DxgiFormat)
+		}
+		if _, hasLegacyPixelFormat := dds.LEGACY_PIXEL_FORMAT_MAP[format]; hasLegacyPixelFormat == (header.Dddpf.FourCC == dds.DX10_FORMAT) {
+			t.Errorf("%s: unexpected FourCC %v", formatInfo.Name, header.Dddpf.FourCC)
+		}
+		for i := range mips {
+			if !bytes.Equal(surfaces[0][i], mips[i]) {
+				t.Errorf("%s: expected mip %d to be read back", formatInfo.Name, i)
+			}
+		}
+	}
+}
@@ -2,11 +2,16 @@ package test
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/3096/furnace/dds"
+	"github.com/3096/furnace/furnace"
 	"github.com/3096/furnace/furnace/formats"
 	"github.com/3096/furnace/utils"
 )
@@ -153,9 +158,1222 @@ func TestMIBL(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	footer, err := mibl.GetFooter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if footer.Depth != 1 || footer.ViewDimension != formats.MIBL_VIEW_DIMENSION_2D {
+		t.Errorf("Expected depth 1 and a 2D view dimension like the game files, got %d and %d", footer.Depth,
+			footer.ViewDimension)
+	}
 
 	err = ioutil.WriteFile(miblOutFilePath, mibl, 0644)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestMIBLGetMips(t *testing.T) {
+	miblTestTexturePath := "formats_testdata/mibl/03.PC060000_KIZU_ALP.dds"
+
+	miblTestTextureFile, err := os.Open(miblTestTexturePath)
+	defer miblTestTextureFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, headerDX10, textures, err := dds.LoadDDS(miblTestTextureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mibl, err := formats.NewMIBL(textures[0], header.Width, header.Height, headerDX10.DxgiFormat, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mibl, err = formats.ReadMIBL(bytes.NewReader(mibl))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mips, err := mibl.GetMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mips) != len(textures[0]) {
+		t.Fatalf("Expected %d mips, got %d", len(textures[0]), len(mips))
+	}
+	for i := range mips {
+		if !bytes.Equal(mips[i], textures[0][i]) {
+			t.Errorf("Expected mip %d to match %s", i, miblTestTexturePath)
+		}
+	}
+}
+
+func TestMIBLWismtRoundTrip(t *testing.T) {
+	msrd := readTestMSRD(t, "formats_testdata/wismt/pc079404.wismt")
+
+	splitMips, err := msrd.GetSplitMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, mibl := range append(splitMips, cachedTextures...) {
+		footer, err := mibl.GetFooter()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the game files have these whatever the texture size, so NewMIBL can't write the size of the last mip there
+		if footer.Depth != 1 || footer.ViewDimension != formats.MIBL_VIEW_DIMENSION_2D {
+			t.Errorf("Expected MIBL %d to have depth 1 and a 2D view dimension, got %d and %d", i, footer.Depth,
+				footer.ViewDimension)
+		}
+		mips, err := mibl.GetMips()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rebuiltMIBL, err := formats.NewMIBL(mips, footer.Width, footer.Height, formats.MIBLFormatToDXGIFormat[footer.Format], 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rebuiltMIBL, mibl) {
+			t.Errorf("Expected MIBL %d to be rebuilt identically from its decoded mips", i)
+		}
+	}
+}
+
+func TestMSRDTextureNames(t *testing.T) {
+	msrd := readTestMSRD(t, "formats_testdata/wismt/pc079404.wismt")
+
+	expectedNames := []string{"PC079404_WAIST", "PC079404_WAIST_ALP", "PC079404_WAIST_NRM", "PC079404_WAIST_SHY", "PC079404_WAIST_MTL"}
+	if len(msrd.TextureNames) != len(expectedNames) {
+		t.Fatalf("Expected %d texture names, got %v", len(expectedNames), msrd.TextureNames)
+	}
+	for i, expectedName := range expectedNames {
+		if msrd.TextureNames[i] != expectedName {
+			t.Errorf("Expected texture %d to be named %s, got %s", i, expectedName, msrd.TextureNames[i])
+		}
+		textureIds := msrd.GetTextureIdsByName(expectedName)
+		if len(textureIds) != 1 || textureIds[0] != formats.MSRDTextureId(i) {
+			t.Errorf("Expected %s to be found as texture %d, got %v", expectedName, i, textureIds)
+		}
+	}
+}
+
+func TestMSRDMetaDataResize(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	mxmdTestFilePath := "formats_testdata/wismt/pc079404.wimdo"
+
+	for _, textureName := range []string{"PC079404_WAIST_RENAMED_TO_SOMETHING_MUCH_LONGER", "W"} {
+		msrdOutFilePath := "formats_testdata/test-out/msrd-resize/" + textureName + ".wismt"
+		err := utils.EnsureDirectory(msrdOutFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		msrd := readTestMSRD(t, msrdTestFilePath)
+		origMetaData, origMetaDataSize := msrd.MetaData, msrd.Header.MetaDataSize
+		msrd.TextureNames[0] = textureName
+		if err := msrd.UpdateMetaData(); err != nil {
+			t.Fatal(err)
+		}
+		if msrd.Header.MetaDataSize == origMetaDataSize || msrd.Header.MetaDataSize%formats.MSRD_FILE_ALIGN != 0 {
+			t.Errorf("Expected metadata to be resized and aligned, got size %d", msrd.Header.MetaDataSize)
+		}
+
+		msrdFileOut, err := os.Create(msrdOutFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = formats.WriteMSRD(msrdFileOut, msrd)
+		msrdFileOut.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		origMSRD := readTestMSRD(t, msrdTestFilePath)
+		outMSRD := readTestMSRD(t, msrdOutFilePath)
+		if outMSRD.TextureNames[0] != textureName || outMSRD.TextureNames[1] != origMSRD.TextureNames[1] {
+			t.Errorf("Expected texture names to be read back, got %v", outMSRD.TextureNames)
+		}
+		for i := range origMSRD.CompressedFiles {
+			if !bytes.Equal(origMSRD.CompressedFiles[i], outMSRD.CompressedFiles[i]) {
+				t.Errorf("Expected file %d to be moved intact", i)
+			}
+		}
+
+		mxmdFile, err := ioutil.ReadFile(mxmdTestFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mxmd := formats.MXMD(mxmdFile)
+		mxmdHeader, err := mxmd.GetHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mxmd.SetUncachedTextures(origMetaData, outMSRD.MetaData); err != nil {
+			t.Fatal(err)
+		}
+		metaDataEnd := mxmdHeader.UncachedTexturesOffset + outMSRD.Header.MetaDataSize
+		if !bytes.Equal(mxmd[mxmdHeader.UncachedTexturesOffset:metaDataEnd], outMSRD.MetaData) ||
+			uint32(len(mxmd)) != utils.Align(metaDataEnd, formats.MXMD_ALIGN) {
+			t.Errorf("Expected mxmd to embed the resized metadata")
+		}
+	}
+}
+
+func TestMSRDAddTexture(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	msrdOutFilePath := "formats_testdata/test-out/msrd-add-texture/pc079404.wismt"
+	newTextureName := "PC079404_NEW"
+
+	err := utils.EnsureDirectory(msrdOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	cachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	splitMips, err := msrd.GetSplitMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, highResData, err := formats.ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[formats.MSRD_FILE_INDEX_TEXTURE_START]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	textureId, err := msrd.AddTexture(newTextureName, formats.MSRD_TEXTURE_USAGE_COL, cachedTextures[0], splitMips[0], highResData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msrdFileOut, err := os.Create(msrdOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = formats.WriteMSRD(msrdFileOut, msrd)
+	msrdFileOut.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outMSRD := readTestMSRD(t, msrdOutFilePath)
+	if int(textureId) != len(outMSRD.TextureNames)-1 || outMSRD.TextureNames[textureId] != newTextureName {
+		t.Fatalf("Expected texture %d to be named %s, got %v", textureId, newTextureName, outMSRD.TextureNames)
+	}
+	fileIndex := formats.MSRD_FILE_INDEX_TEXTURE_START + outMSRD.TextureIdToIndexMap[textureId]
+	if fileIndex != len(outMSRD.CompressedFiles)-1 {
+		t.Fatalf("Expected texture %d to be streamed from the last file, got file %d", textureId, fileIndex)
+	}
+	header, outHighResData, err := formats.ExtractXBC1(bytes.NewReader(outMSRD.CompressedFiles[fileIndex]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.GetName() != fmt.Sprintf("%04d", fileIndex) || !bytes.Equal(outHighResData, highResData) {
+		t.Errorf("Expected file %d to hold the new high-res texture, got %s", fileIndex, header.GetName())
+	}
+
+	outCachedTextures, err := outMSRD.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outSplitMips, err := outMSRD.GetSplitMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outCachedTextures) != len(outMSRD.TextureInfoItems) || len(outSplitMips) != len(outMSRD.TextureIdToIndexMap) {
+		t.Fatalf("Expected %d cached textures and %d split mips, got %d and %d", len(outMSRD.TextureInfoItems),
+			len(outMSRD.TextureIdToIndexMap), len(outCachedTextures), len(outSplitMips))
+	}
+	if !bytes.Equal(outCachedTextures[textureId], cachedTextures[0]) || !bytes.Equal(outSplitMips[len(outSplitMips)-1], splitMips[0]) {
+		t.Errorf("Expected the new texture's cache and mips to be saved")
+	}
+}
+
+func TestMSRDDataItemReplace(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	origCachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	origDataItems := append([]formats.MSRDDataItem{}, msrd.DataItems...)
+	var origData [][]byte
+	for i := range msrd.DataItems {
+		data, err := msrd.GetDataItemData(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		origData = append(origData, data)
+	}
+
+	shaderIndex := findTestDataItem(t, &msrd, formats.MSRD_DATA_ITEM_TYPE_SHADERBUNDLE)
+	newShaderData := append(append([]byte{}, origData[shaderIndex]...), bytes.Repeat([]byte{0xAB}, 5000)...)
+	if err := msrd.SetDataItemData(shaderIndex, newShaderData); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range msrd.DataItems {
+		data, err := msrd.GetDataItemData(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == shaderIndex {
+			if !bytes.Equal(data, newShaderData) {
+				t.Errorf("Expected data item %d to hold the new data", i)
+			}
+		} else if !bytes.Equal(data, origData[i]) {
+			t.Errorf("Expected data item %d to be preserved", i)
+		}
+		if msrd.DataItems[i].Offset%formats.MSRD_DATA_ITEM_ALIGN != 0 {
+			t.Errorf("Expected data item %d to stay aligned, got offset %d", i, msrd.DataItems[i].Offset)
+		}
+	}
+	textureCacheIndex := findTestDataItem(t, &msrd, formats.MSRD_DATA_ITEM_TYPE_TEXTURECACHE)
+	if msrd.DataItems[textureCacheIndex].Offset <= origDataItems[textureCacheIndex].Offset {
+		t.Errorf("Expected the texture cache to move after the grown shader bundle")
+	}
+	cachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range cachedTextures {
+		if !bytes.Equal(cachedTextures[i], origCachedTextures[i]) {
+			t.Errorf("Expected cached texture %d to be preserved", i)
+		}
+	}
+
+	if err := msrd.SetDataItemData(shaderIndex, origData[shaderIndex]); err != nil {
+		t.Fatal(err)
+	}
+	for i := range msrd.DataItems {
+		if msrd.DataItems[i] != origDataItems[i] {
+			t.Errorf("Expected data item %d to be back in place, got %+v", i, msrd.DataItems[i])
+		}
+	}
+}
+
+func TestMSRDDataItemInOtherFile(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+
+	// move the shader bundle to a file of its own
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	shaderIndex := findTestDataItem(t, &msrd, formats.MSRD_DATA_ITEM_TYPE_SHADERBUNDLE)
+	shaderData, err := msrd.GetDataItemData(shaderIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shaderFileIndex := len(msrd.CompressedFiles)
+	shaderFile, err := formats.CompressToXBC1(formats.GetMSRDFileName(shaderFileIndex), shaderData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msrd.CompressedFiles = append(msrd.CompressedFiles, nil)
+	msrd.SetCompressedFileData(shaderFileIndex, shaderFile)
+	origFile0 := msrd.CompressedFiles[formats.MSRD_FILE_INDEX_0]
+	msrd.DataItems[shaderIndex].Offset = 0
+	msrd.DataItems[shaderIndex].FileIndexPlusOne = uint16(shaderFileIndex + 1)
+
+	if fileIndex := msrd.GetDataItemFileIndex(shaderIndex); fileIndex != shaderFileIndex {
+		t.Fatalf("Expected the shader bundle to be in file %d, got %d", shaderFileIndex, fileIndex)
+	}
+	outShaderData, err := msrd.GetDataItemData(shaderIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(outShaderData, shaderData) {
+		t.Errorf("Expected the shader bundle to be read from file %d", shaderFileIndex)
+	}
+
+	newShaderData := append(append([]byte{}, shaderData...), bytes.Repeat([]byte{0xAB}, 5000)...)
+	if err := msrd.SetDataItemData(shaderIndex, newShaderData); err != nil {
+		t.Fatal(err)
+	}
+	_, shaderFileContent, err := formats.ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[shaderFileIndex]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(shaderFileContent, newShaderData) {
+		t.Errorf("Expected the new shader bundle to be written to file %d", shaderFileIndex)
+	}
+	if !bytes.Equal(msrd.CompressedFiles[formats.MSRD_FILE_INDEX_0], origFile0) {
+		t.Errorf("Expected file 0 to be left alone")
+	}
+
+	msrd.DataItems[shaderIndex].FileIndexPlusOne = uint16(len(msrd.CompressedFiles) + 1)
+	if _, err := msrd.GetDataItemData(shaderIndex); err == nil {
+		t.Errorf("Expected a data item in a missing file to be rejected")
+	}
+}
+
+func TestMSRDTextureCacheNotAtEnd(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+
+	// move the shader bundle after the texture cache
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	file0Header, file0Content, err := formats.ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[formats.MSRD_FILE_INDEX_0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shaderIndex := findTestDataItem(t, &msrd, formats.MSRD_DATA_ITEM_TYPE_SHADERBUNDLE)
+	shaderItem := &msrd.DataItems[shaderIndex]
+	textureCacheItem := &msrd.DataItems[findTestDataItem(t, &msrd, formats.MSRD_DATA_ITEM_TYPE_TEXTURECACHE)]
+	shaderData := append([]byte{}, file0Content[shaderItem.Offset:shaderItem.Offset+shaderItem.Size]...)
+	textureCacheData := file0Content[textureCacheItem.Offset : textureCacheItem.Offset+textureCacheItem.Size]
+	file0Content = append(append(file0Content[:shaderItem.Offset:shaderItem.Offset], textureCacheData...), shaderData...)
+	textureCacheItem.Offset = shaderItem.Offset
+	shaderItem.Offset = textureCacheItem.Offset + textureCacheItem.Size
+	file0, err := formats.CompressToXBC1(file0Header.Name, file0Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msrd.SetCompressedFileData(formats.MSRD_FILE_INDEX_0, file0)
+
+	cachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	splitMips, err := msrd.GetSplitMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachedTextures[1] = splitMips[1]
+	if err := msrd.SetCachedTextures(cachedTextures); err != nil {
+		t.Fatal(err)
+	}
+
+	outCachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range cachedTextures {
+		if !bytes.Equal(outCachedTextures[i], cachedTextures[i]) {
+			t.Errorf("Expected cached texture %d to be saved", i)
+		}
+	}
+	outShaderData, err := msrd.GetDataItemData(shaderIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(outShaderData, shaderData) || shaderItem.Offset < textureCacheItem.Offset+textureCacheItem.Size ||
+		shaderItem.Offset%formats.MSRD_DATA_ITEM_ALIGN != 0 {
+		t.Errorf("Expected the shader bundle to move after the grown texture cache, got offset %d", shaderItem.Offset)
+	}
+}
+
+func TestXBC1CompressionTypes(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	for i, compressedFile := range msrd.CompressedFiles {
+		header, err := formats.ReadXBC1Header(bytes.NewReader(compressedFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.CompressionType != formats.XBC1_COMPRESSION_TYPE_ZLIB {
+			t.Errorf("Expected file %d to be zlib compressed, got %s", i, header.CompressionType)
+		}
+	}
+
+	var name [0x1C]byte
+	copy(name[:], "test")
+	data := bytes.Repeat([]byte("furnace"), 1000)
+	compressedFile, err := formats.CompressToXBC1WithType(formats.XBC1_COMPRESSION_TYPE_ZLIB, name, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// trailing data after the compressed stream is not part of the file
+	header, outData, err := formats.ExtractXBC1(bytes.NewReader(append(compressedFile, 0xFF, 0xFF)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(outData, data) || header.GetName() != "test" || header.CompressionType != formats.XBC1_COMPRESSION_TYPE_ZLIB {
+		t.Errorf("Expected zlib xbc1 to round trip, got %+v", header)
+	}
+
+	unknownCompressionType := formats.XBC1CompressionType(2)
+	if _, err := formats.CompressToXBC1WithType(unknownCompressionType, name, data); err == nil {
+		t.Errorf("Expected compression type %s to be rejected", unknownCompressionType)
+	}
+	unknownCompressedFile := append(formats.XBC1{}, compressedFile...)
+	furnace.TargetByteOrder.PutUint32(unknownCompressedFile[4:], uint32(unknownCompressionType))
+	if _, _, err := formats.ExtractXBC1(bytes.NewReader(unknownCompressedFile)); err == nil {
+		t.Errorf("Expected compression type %s to be rejected", unknownCompressionType)
+	}
+	wrongSizeFile := append(formats.XBC1{}, compressedFile...)
+	furnace.TargetByteOrder.PutUint32(wrongSizeFile[8:], uint32(len(data)+1))
+	if _, _, err := formats.ExtractXBC1(bytes.NewReader(wrongSizeFile)); err == nil {
+		t.Errorf("Expected a wrong uncompressed size to be rejected")
+	}
+}
+
+func TestMSRDUnchangedFilesKeepHash(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	origMSRD := readTestMSRD(t, msrdTestFilePath)
+	cachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	splitMips, err := msrd.GetSplitMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msrd.SetCachedTextures(cachedTextures); err != nil {
+		t.Fatal(err)
+	}
+	if err := msrd.SetMips(splitMips); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range origMSRD.CompressedFiles {
+		header, err := formats.ReadXBC1Header(bytes.NewReader(msrd.CompressedFiles[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msrd.CompressedFiles[i], origMSRD.CompressedFiles[i]) || header.Hash == 0 {
+			t.Errorf("Expected unchanged file %d to keep its original data and hash", i)
+		}
+	}
+}
+
+func TestXBC1Streaming(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	xbc1OutFilePath := "formats_testdata/test-out/xbc1-streaming/0000.xbc1"
+
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	_, data, err := formats.ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[formats.MSRD_FILE_INDEX_0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the prefix gets decompressed
+	header, xbc1Reader, err := formats.NewXBC1Reader(bytes.NewReader(msrd.CompressedFiles[formats.MSRD_FILE_INDEX_0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := make([]byte, 16)
+	if _, err := io.ReadFull(xbc1Reader, prefix); err != nil {
+		t.Fatal(err)
+	}
+	if err := xbc1Reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(prefix, data[:len(prefix)]) {
+		t.Errorf("Expected the streamed prefix to match the extracted data")
+	}
+
+	err = utils.EnsureDirectory(xbc1OutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xbc1OutFile, err := os.Create(xbc1OutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer xbc1OutFile.Close()
+	leadingData := []byte("leading data")
+	if _, err := xbc1OutFile.Write(leadingData); err != nil {
+		t.Fatal(err)
+	}
+	xbc1Writer, err := formats.NewXBC1Writer(xbc1OutFile, header.CompressionType, formats.XBC1_COMPRESSION_LEVEL_DEFAULT, header.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for offset := 0; offset < len(data); offset += 10000 {
+		end := offset + 10000
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := xbc1Writer.Write(data[offset:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := xbc1Writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	xbc1OutData, err := ioutil.ReadFile(xbc1OutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressedFile, err := formats.CompressToXBC1WithType(header.CompressionType, header.Name, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(xbc1OutData[:len(leadingData)], leadingData) || !bytes.Equal(xbc1OutData[len(leadingData):], compressedFile) {
+		t.Errorf("Expected the streamed xbc1 to match the one compressed at once")
+	}
+	if outHeader := xbc1Writer.Header(); outHeader.UncompressedSize != uint32(len(data)) ||
+		outHeader.CompressedSize != uint32(len(compressedFile)-binary.Size(outHeader)) {
+		t.Errorf("Expected the sizes to be filled in, got %+v", outHeader)
+	}
+}
+
+func TestXBC1ZstdReference(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	// file 3 of the wismt compressed by the reference zstd command line tool (v1.5.6, zstd -19), behind the original
+	// xbc1 header with the compression type and sizes changed. It isn't a game file.
+	xbc1TestFilePath := "formats_testdata/xbc1/0003-zstd.xbc1"
+
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	origHeader, origData, err := formats.ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zstdFile, err := ioutil.ReadFile(xbc1TestFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, data, err := formats.ExtractXBC1(bytes.NewReader(zstdFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.CompressionType != formats.XBC1_COMPRESSION_TYPE_ZSTD || header.GetName() != origHeader.GetName() ||
+		header.CompressedSize != uint32(len(zstdFile)-binary.Size(header)) {
+		t.Errorf("Unexpected header %+v", header)
+	}
+	if !bytes.Equal(data, origData) {
+		t.Errorf("Expected the reference zstd data to decompress to file 3")
+	}
+
+	// the zstd command line tool adds a checksum to the frame, which gets checked
+	zstdFile[len(zstdFile)-1] ^= 0xFF
+	if _, _, err := formats.ExtractXBC1(bytes.NewReader(zstdFile)); err == nil {
+		t.Errorf("Expected a corrupted frame checksum to be rejected")
+	}
+}
+
+func TestXBC1CompressionLevels(t *testing.T) {
+	var name [0x1C]byte
+	copy(name[:], "test")
+	data := bytes.Repeat([]byte("furnace levels "), 4000)
+	for i := range data {
+		if i%7 == 0 {
+			data[i] = byte(i)
+		}
+	}
+
+	fastFile, err := formats.CompressToXBC1WithLevel(formats.XBC1_COMPRESSION_TYPE_ZLIB, formats.XBC1_COMPRESSION_LEVEL_FAST, name, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bestFile, err := formats.CompressToXBC1WithLevel(formats.XBC1_COMPRESSION_TYPE_ZLIB, formats.XBC1_COMPRESSION_LEVEL_BEST, name, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultFile, err := formats.CompressToXBC1(name, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(defaultFile, bestFile) {
+		t.Errorf("Expected the default level to compress the same as the best level")
+	}
+	if len(bestFile) > len(fastFile) {
+		t.Errorf("Expected the best level to be no larger than the fast level, got %d and %d bytes", len(bestFile), len(fastFile))
+	}
+	for _, compressedFile := range []formats.XBC1{fastFile, bestFile} {
+		_, outData, err := formats.ExtractXBC1(bytes.NewReader(compressedFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(outData, data) {
+			t.Errorf("Expected data compressed at any level to round trip")
+		}
+	}
+
+	invalidLevel := formats.XBC1CompressionLevel(10)
+	if _, err := formats.CompressToXBC1WithLevel(formats.XBC1_COMPRESSION_TYPE_ZLIB, invalidLevel, name, data); err == nil {
+		t.Errorf("Expected compression level %s to be rejected", invalidLevel)
+	}
+}
+
+func TestXBC1Zstd(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	origHeader, data, err := formats.ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[formats.MSRD_FILE_INDEX_0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zstdMagic := []byte{0x28, 0xB5, 0x2F, 0xFD}
+	for _, level := range []formats.XBC1CompressionLevel{formats.XBC1_COMPRESSION_LEVEL_DEFAULT,
+		formats.XBC1_COMPRESSION_LEVEL_FAST, 4, 7, formats.XBC1_COMPRESSION_LEVEL_BEST} {
+
+		compressedFile, err := formats.CompressToXBC1WithLevel(formats.XBC1_COMPRESSION_TYPE_ZSTD, level, origHeader.Name, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, outData, err := formats.ExtractXBC1(bytes.NewReader(compressedFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.CompressionType != formats.XBC1_COMPRESSION_TYPE_ZSTD || header.GetName() != origHeader.GetName() ||
+			!bytes.Equal(outData, data) {
+			t.Errorf("Expected zstd xbc1 at level %s to round trip, got %+v", level, header)
+		}
+		if compressedData := compressedFile[binary.Size(header):]; !bytes.Equal(compressedData[:len(zstdMagic)], zstdMagic) {
+			t.Errorf("Expected a zstd frame at level %s, got % X", level, compressedData[:len(zstdMagic)])
+		}
+	}
+
+	emptyFile, err := formats.CompressToXBC1WithType(formats.XBC1_COMPRESSION_TYPE_ZSTD, origHeader.Name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header, outData, err := formats.ExtractXBC1(bytes.NewReader(emptyFile)); err != nil || header.CompressedSize == 0 || len(outData) != 0 {
+		t.Errorf("Expected an empty zstd xbc1 to hold an empty frame, got %+v, %v", header, err)
+	}
+
+	// a wismt compressed with zstd keeps adding zstd files
+	for i, compressedFile := range msrd.CompressedFiles {
+		header, fileData, err := formats.ExtractXBC1(bytes.NewReader(compressedFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zstdFile, err := formats.CompressToXBC1WithType(formats.XBC1_COMPRESSION_TYPE_ZSTD, header.Name, fileData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msrd.SetCompressedFileData(i, zstdFile)
+	}
+	if msrd.GetCompressionType() != formats.XBC1_COMPRESSION_TYPE_ZSTD {
+		t.Fatalf("Expected the wismt compression type to be zstd, got %s", msrd.GetCompressionType())
+	}
+	// item 0 is the model, in file 0
+	if err := msrd.SetDataItemData(0, data[:16]); err != nil {
+		t.Fatal(err)
+	}
+	header, err := formats.ReadXBC1Header(bytes.NewReader(msrd.CompressedFiles[formats.MSRD_FILE_INDEX_0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.CompressionType != formats.XBC1_COMPRESSION_TYPE_ZSTD {
+		t.Errorf("Expected a replaced data item to keep the zstd compression, got %s", header.CompressionType)
+	}
+}
+
+func TestMSRDVersions(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+
+	msrdData, err := ioutil.ReadFile(msrdTestFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	if msrd.Header.Version != formats.MSRD_VERSION || msrd.MetaHeader.Tag != formats.MSRD_META_TAG {
+		t.Errorf("Expected the test file to have the supported version and tag, got %d and 0x%X", msrd.Header.Version, msrd.MetaHeader.Tag)
+	}
+
+	metaDataOffset := int(msrd.Header.MetaDataOffset)
+	for _, testCase := range []struct {
+		name          string
+		offset        int
+		value         uint32
+		expectedError string
+	}{
+		{"version", 4, 10002, "Unsupported msrd version 10002"},
+		{"tag", metaDataOffset, 0x1000, "Unsupported msrd metadata tag 0x1000"},
+		{"data items count", metaDataOffset + 8, 0x1000, "Invalid msrd data items"},
+		{"texture ids offset", metaDataOffset + 0x38, 0x10000, "Invalid msrd texture ids"},
+	} {
+		changedData := append([]byte{}, msrdData...)
+		furnace.TargetByteOrder.PutUint32(changedData[testCase.offset:], testCase.value)
+		_, err := formats.ReadMSRD(bytes.NewReader(changedData))
+		if err == nil || !strings.HasPrefix(err.Error(), testCase.expectedError) {
+			t.Errorf("Expected a changed %s to fail with %q, got %v", testCase.name, testCase.expectedError, err)
+		}
+	}
+}
+
+func TestMSRDNamedFields(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	msrdOutFilePath := "formats_testdata/test-out/msrd-named-fields/pc079404.wismt"
+
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	metaHeader := msrd.MetaHeader
+	for _, dataItemIndex := range []struct {
+		index        uint32
+		expectedType formats.MSRDDataItemType
+	}{
+		{metaHeader.ModelDataItemIndex, formats.MSRD_DATA_ITEM_TYPE_MODEL},
+		{metaHeader.ShaderBundleDataItemIndex, formats.MSRD_DATA_ITEM_TYPE_SHADERBUNDLE},
+		{metaHeader.TextureCacheDataItemIndex, formats.MSRD_DATA_ITEM_TYPE_TEXTURECACHE},
+	} {
+		if msrd.DataItems[dataItemIndex.index].Type != dataItemIndex.expectedType {
+			t.Errorf("Expected data item %d to be the %s, got %s", dataItemIndex.index, dataItemIndex.expectedType,
+				msrd.DataItems[dataItemIndex.index].Type)
+		}
+	}
+	if metaHeader.TextureCacheFileIndex != formats.MSRD_FILE_INDEX_0 || metaHeader.MipsFileIndex != formats.MSRD_FILE_INDEX_MIPS {
+		t.Errorf("Expected the texture cache in file 0 and the mips in file 1, got %+v", metaHeader)
+	}
+	for i, dataItem := range msrd.DataItems {
+		if dataItem.Reserved != [2]uint32{} {
+			t.Errorf("Expected data item %d to have no reserved values, got %v", i, dataItem.Reserved)
+		}
+	}
+
+	expectedUsages := []formats.MSRDTextureUsage{formats.MSRD_TEXTURE_USAGE_COL, formats.MSRD_TEXTURE_USAGE_ALP,
+		formats.MSRD_TEXTURE_USAGE_NRM, formats.MSRD_TEXTURE_USAGE_TEMP, formats.MSRD_TEXTURE_USAGE_TEMP}
+	for i, textureInfoItem := range msrd.TextureInfoItems {
+		if textureInfoItem.Usage != expectedUsages[i] {
+			t.Errorf("Expected texture %s to be %s, got %s", msrd.TextureNames[i], expectedUsages[i], textureInfoItem.Usage)
+		}
+	}
+	for name, expectedUsage := range map[string]formats.MSRDTextureUsage{
+		"PC000000_NRM": formats.MSRD_TEXTURE_USAGE_NRM,
+		"PC000000_ALP": formats.MSRD_TEXTURE_USAGE_ALP,
+		"PC000000_NEW": formats.MSRD_TEXTURE_USAGE_COL,
+		"PC000000":     formats.MSRD_TEXTURE_USAGE_COL,
+	} {
+		if usage := msrd.GuessTextureUsage(name); usage != expectedUsage {
+			t.Errorf("Expected %s to be guessed as %s, got %s", name, expectedUsage, usage)
+		}
+	}
+
+	// the fields are written back with the metadata
+	msrd.MetaHeader.MipsFileIndex = 5
+	msrd.DataItems[0].Reserved[1] = 7
+	msrd.TextureInfoItems[0].Usage = formats.MSRD_TEXTURE_USAGE_NRM
+	if err := msrd.UpdateMetaData(); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.EnsureDirectory(msrdOutFilePath); err != nil {
+		t.Fatal(err)
+	}
+	msrdFileOut, err := os.Create(msrdOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = formats.WriteMSRD(msrdFileOut, msrd)
+	msrdFileOut.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outMsrd := readTestMSRD(t, msrdOutFilePath)
+	if outMsrd.MetaHeader != msrd.MetaHeader || outMsrd.DataItems[0].Reserved[1] != 7 ||
+		outMsrd.TextureInfoItems[0].Usage != formats.MSRD_TEXTURE_USAGE_NRM {
+		t.Errorf("Expected the named fields to round trip, got %+v", outMsrd.MetaHeader)
+	}
+}
+
+func TestMSRDHeaderFileIndices(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+
+	// move the mips to a new file and the texture cache to another new file, leaving garbage behind
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	origCachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	origSplitMips, err := msrd.GetSplitMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	textureCacheIndex := int(msrd.MetaHeader.TextureCacheDataItemIndex)
+	textureCache, err := msrd.GetDataItemData(textureCacheIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbageFile, err := formats.CompressToXBC1(formats.GetMSRDFileName(formats.MSRD_FILE_INDEX_MIPS), []byte("garbage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mipsFileIndex := len(msrd.CompressedFiles)
+	msrd.CompressedFiles = append(msrd.CompressedFiles, msrd.CompressedFiles[formats.MSRD_FILE_INDEX_MIPS])
+	msrd.SetCompressedFileData(formats.MSRD_FILE_INDEX_MIPS, garbageFile)
+	msrd.MetaHeader.MipsFileIndex = uint32(mipsFileIndex)
+	textureCacheFileIndex := len(msrd.CompressedFiles)
+	textureCacheFile, err := formats.CompressToXBC1(formats.GetMSRDFileName(textureCacheFileIndex), textureCache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msrd.CompressedFiles = append(msrd.CompressedFiles, nil)
+	msrd.SetCompressedFileData(textureCacheFileIndex, textureCacheFile)
+	msrd.MetaHeader.TextureCacheFileIndex = uint32(textureCacheFileIndex)
+	msrd.DataItems[textureCacheIndex].Offset = 0
+
+	cachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	splitMips, err := msrd.GetSplitMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range origCachedTextures {
+		if !bytes.Equal(cachedTextures[i], origCachedTextures[i]) {
+			t.Errorf("Expected cached texture %d to be read from file %d", i, textureCacheFileIndex)
+		}
+	}
+	for i := range origSplitMips {
+		if !bytes.Equal(splitMips[i], origSplitMips[i]) {
+			t.Errorf("Expected mips %d to be read from file %d", i, mipsFileIndex)
+		}
+	}
+
+	// saving writes to the same files
+	origFile0 := msrd.CompressedFiles[formats.MSRD_FILE_INDEX_0]
+	origMipsFile := msrd.CompressedFiles[formats.MSRD_FILE_INDEX_MIPS]
+	splitMips[0], splitMips[1] = splitMips[1], splitMips[0]
+	cachedTextures[0], cachedTextures[1] = cachedTextures[1], cachedTextures[0]
+	if err := msrd.SetMips(splitMips); err != nil {
+		t.Fatal(err)
+	}
+	if err := msrd.SetCachedTextures(cachedTextures); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msrd.CompressedFiles[formats.MSRD_FILE_INDEX_0], origFile0) ||
+		!bytes.Equal(msrd.CompressedFiles[formats.MSRD_FILE_INDEX_MIPS], origMipsFile) {
+		t.Errorf("Expected files 0 and 1 to be left alone")
+	}
+	outCachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outSplitMips, err := msrd.GetSplitMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(outCachedTextures[0], origCachedTextures[1]) || !bytes.Equal(outSplitMips[0], origSplitMips[1]) {
+		t.Errorf("Expected the textures to be saved to files %d and %d", textureCacheFileIndex, mipsFileIndex)
+	}
+
+	msrd.MetaHeader.TextureCacheDataItemIndex = msrd.MetaHeader.ModelDataItemIndex
+	if _, err := msrd.GetCachedTextures(); err == nil {
+		t.Errorf("Expected a texture cache index pointing to the model to be rejected")
+	}
+	msrd.MetaHeader.TextureDataItemsCount = uint32(len(msrd.DataItems))
+	if _, err := msrd.GetSplitMips(); err == nil {
+		t.Errorf("Expected texture data items out of range to be rejected")
+	}
+
+	// adding a texture keeps the texture data items in sync
+	msrd = readTestMSRD(t, msrdTestFilePath)
+	origMetaHeader := msrd.MetaHeader
+	if _, err := msrd.AddTexture("PC000000_NEW", formats.MSRD_TEXTURE_USAGE_COL, origCachedTextures[0], origSplitMips[0], []byte("high res")); err != nil {
+		t.Fatal(err)
+	}
+	newDataItemIndex := len(msrd.DataItems) - 1
+	if msrd.MetaHeader.TextureDataItemsStart != origMetaHeader.TextureDataItemsStart ||
+		msrd.MetaHeader.TextureDataItemsCount != origMetaHeader.TextureDataItemsCount+1 ||
+		msrd.MetaHeader.TextureIdsCount != origMetaHeader.TextureIdsCount+1 ||
+		msrd.GetDataItemFileIndex(newDataItemIndex) != int(msrd.MetaHeader.MipsFileIndex) {
+		t.Errorf("Expected the new data item to be counted as a texture data item, got %+v", msrd.MetaHeader)
+	}
+	splitMips, err = msrd.GetSplitMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(splitMips) != int(msrd.MetaHeader.TextureDataItemsCount) || !bytes.Equal(splitMips[len(splitMips)-1], origSplitMips[0]) {
+		t.Errorf("Expected the new mips to be read back from the new texture data item")
+	}
+}
+
+func TestMXMDParse(t *testing.T) {
+	mxmdTestFilePath := "formats_testdata/wismt/pc079404.wimdo"
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+
+	mxmdData, err := ioutil.ReadFile(mxmdTestFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mxmdFile, err := formats.ParseMXMD(formats.MXMD(mxmdData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roundTrip, err := mxmdFile.GetMXMD()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(roundTrip, mxmdData) {
+		t.Errorf("Expected an unchanged mxmd to be written back byte for byte")
+	}
+
+	if mxmdFile.Models == nil || len(mxmdFile.Models.Models) != 1 || len(mxmdFile.Models.Models[0].Meshes) != 4 {
+		t.Fatalf("Expected 1 model with 4 meshes")
+	}
+	for i, mesh := range mxmdFile.Models.Models[0].Meshes {
+		if int(mesh.MaterialIndex) != i {
+			t.Errorf("Expected mesh %d to use material %d, got %d", i, i, mesh.MaterialIndex)
+		}
+	}
+	skinning := mxmdFile.Models.Skinning
+	if skinning == nil || len(skinning.BoneNames) != 7 || skinning.BoneNames[0] != "JLtg_L" {
+		t.Errorf("Expected 7 bones starting with JLtg_L, got %v", skinning)
+	}
+
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	materialNames := []string{"pc_waist", "pc_waist_skin", "pc_waist_2", "pc_waist_belt"}
+	if mxmdFile.Materials == nil || len(mxmdFile.Materials.Materials) != len(materialNames) {
+		t.Fatalf("Expected %d materials", len(materialNames))
+	}
+	for i, material := range mxmdFile.Materials.Materials {
+		if material.Name != materialNames[i] {
+			t.Errorf("Expected material %d to be %s, got %s", i, materialNames[i], material.Name)
+		}
+		for _, texture := range material.Textures {
+			if int(texture.TextureIndex) >= len(msrd.TextureNames) {
+				t.Errorf("Material %s uses texture %d out of %d", material.Name, texture.TextureIndex,
+					len(msrd.TextureNames))
+			}
+		}
+	}
+
+	if mxmdFile.UncachedTextures == nil ||
+		fmt.Sprint(mxmdFile.UncachedTextures.TextureNames) != fmt.Sprint(msrd.TextureNames) {
+		t.Errorf("Expected the uncached textures to match the msrd")
+	}
+
+	techniques := mxmdFile.Materials.Techniques
+	if len(techniques) != 2 || len(techniques[0].Attributes) != 5 || len(techniques[0].UniformBlocks) != 7 {
+		t.Fatalf("Expected 2 techniques with 5 attributes and 7 uniform blocks, got %+v", techniques)
+	}
+	for _, material := range mxmdFile.Materials.Materials {
+		for _, technique := range material.Techniques {
+			if int(technique.TechniqueIndex) >= len(techniques) {
+				t.Errorf("Material %s uses technique %d out of %d", material.Name, technique.TechniqueIndex,
+					len(techniques))
+			}
+		}
+	}
+	callbacks := mxmdFile.Materials.Callbacks
+	if callbacks == nil || len(callbacks.WorkCallbacks) != 12 || fmt.Sprint(callbacks.MaterialIndices) != "[0 1 2 3]" {
+		t.Errorf("Expected 12 work callbacks and the 4 material indices, got %+v", callbacks)
+	}
+
+	mxmdFile.Materials.Materials[1].Color = [4]float32{0.25, 0.5, 0.75, 1}
+	edited, err := mxmdFile.GetMXMD()
+	if err != nil {
+		t.Fatal(err)
+	}
+	editedFile, err := formats.ParseMXMD(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if editedFile.Materials.Materials[1].Color != mxmdFile.Materials.Materials[1].Color ||
+		editedFile.Materials.Materials[0].Color == mxmdFile.Materials.Materials[1].Color {
+		t.Errorf("Expected only the edited material color to change")
+	}
+	if len(edited) != len(mxmdData) {
+		t.Errorf("Expected the edited mxmd to keep its size")
+	}
+
+	// a longer name overlaps the next one
+	materials := mxmdFile.Materials
+	materials.Materials[0].Name = materials.Materials[0].Name + "_longer"
+	if _, err := mxmdFile.GetMXMD(); err == nil || !strings.Contains(err.Error(), "overlap") {
+		t.Errorf("Expected a longer name to overlap, got %v", err)
+	}
+
+	// moved past the end of the wimdo, the name and a table can grow
+	newName := materials.Materials[0].Name
+	materials.Materials[0].NameOffset = mxmdFile.Size - mxmdFile.Header.MaterialsOffset
+	callbacks.Header.WorkCallbacksOffset = materials.Materials[0].NameOffset + 0x20
+	callbacks.WorkCallbacks = append(callbacks.WorkCallbacks, [2]uint16{26, 0})
+	callbacks.Header.WorkCallbackCount++
+	if _, err := mxmdFile.GetMXMD(); err == nil {
+		t.Errorf("Expected an error writing past the end of the wimdo")
+	}
+	mxmdFile.Size += 0x80
+	edited, err = mxmdFile.GetMXMD()
+	if err != nil {
+		t.Fatal(err)
+	}
+	editedFile, err = formats.ParseMXMD(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if editedFile.Materials.Materials[0].Name != newName ||
+		len(editedFile.Materials.Callbacks.WorkCallbacks) != len(callbacks.WorkCallbacks) {
+		t.Errorf("Expected the moved name and callbacks to be read back")
+	}
+	if materials.Materials[1].Name != editedFile.Materials.Materials[1].Name {
+		t.Errorf("Expected the other names to be unchanged")
+	}
+
+	mxmdFile.Models.Models[0].Meshes = mxmdFile.Models.Models[0].Meshes[1:]
+	if _, err := mxmdFile.GetMXMD(); err == nil {
+		t.Errorf("Expected an error writing a table that doesn't match its count")
+	}
+
+	for name, offset := range map[string]int{"models": 8, "materials": 12} {
+		badOffset := append(formats.MXMD{}, mxmdData...)
+		furnace.TargetByteOrder.PutUint32(badOffset[offset:], uint32(len(mxmdData))+100)
+		if _, err := formats.ParseMXMD(badOffset); err == nil {
+			t.Errorf("Expected an error for a %s offset past the end", name)
+		}
+		// the section header fits, the tables it points to don't
+		furnace.TargetByteOrder.PutUint32(badOffset[offset:], uint32(len(mxmdData))-0x80)
+		if _, err := formats.ParseMXMD(badOffset); err == nil {
+			t.Errorf("Expected an error for %s tables past the end", name)
+		}
+	}
+	badCount := append(formats.MXMD{}, mxmdData...)
+	furnace.TargetByteOrder.PutUint32(badCount[0xd70+4:], 0xFFFFFFFF)
+	if _, err := formats.ParseMXMD(badCount); err == nil {
+		t.Errorf("Expected an error for a material count past the end")
+	}
+
+	badVersion := append(formats.MXMD{}, mxmdData...)
+	furnace.TargetByteOrder.PutUint32(badVersion[4:], 10111)
+	if _, err := formats.ParseMXMD(badVersion); err == nil || !strings.HasPrefix(err.Error(), "Unsupported mxmd version") {
+		t.Errorf("Expected unknown mxmd version to be rejected, got %v", err)
+	}
+}
+
+func TestMXMDUncachedTextures(t *testing.T) {
+	msrdTestFilePath := "formats_testdata/wismt/pc079404.wismt"
+	mxmdTestFilePath := "formats_testdata/wismt/pc079404.wimdo"
+
+	mxmdData, err := ioutil.ReadFile(mxmdTestFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origMXMD := formats.MXMD(mxmdData)
+	mxmdHeader, err := origMXMD.GetHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := mxmdHeader.UncachedTexturesOffset
+
+	// same layout, only the usage of one texture changes
+	msrd := readTestMSRD(t, msrdTestFilePath)
+	origMetaData := msrd.MetaData
+	msrd.TextureInfoItems[1].Usage = formats.MSRD_TEXTURE_USAGE_TEMP
+	if err := msrd.UpdateMetaData(); err != nil {
+		t.Fatal(err)
+	}
+	mxmd := append(formats.MXMD{}, origMXMD...)
+	if err := mxmd.SetUncachedTextures(origMetaData, msrd.MetaData); err != nil {
+		t.Fatal(err)
+	}
+	if len(mxmd) != len(origMXMD) || !bytes.Equal(mxmd[start:start+uint32(len(msrd.MetaData))], msrd.MetaData) {
+		t.Errorf("Expected the wimdo copy to be updated in place")
+	}
+	tables, err := mxmd.GetUncachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables.TextureInfoItems[1].Usage != formats.MSRD_TEXTURE_USAGE_TEMP {
+		t.Errorf("Expected the usage to be updated, got %v", tables.TextureInfoItems[1].Usage)
+	}
+	changedBytes := 0
+	for i := range mxmd {
+		if mxmd[i] != origMXMD[i] {
+			changedBytes++
+		}
+	}
+	if changedBytes == 0 || changedBytes > binary.Size(formats.MSRDTextureUsage(0)) {
+		t.Errorf("Expected only the usage bytes to change, %d bytes changed", changedBytes)
+	}
+
+	// a wimdo copy that doesn't match the wismt is rejected before anything is written
+	mismatched := append(formats.MXMD{}, origMXMD...)
+	origTables, err := mismatched.GetUncachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	furnace.TargetByteOrder.PutUint16(mismatched[start+origTables.MetaHeader.TextureIdsOffset:], 0x7FFF)
+	mismatchedCopy := append(formats.MXMD{}, mismatched...)
+	err = mismatched.SetUncachedTextures(origMetaData, msrd.MetaData)
+	if err == nil || !strings.Contains(err.Error(), "don't match") || !strings.Contains(err.Error(), "texture ids") {
+		t.Errorf("Expected a mismatched wimdo to be rejected, got %v", err)
+	}
+	if !bytes.Equal(mismatched, mismatchedCopy) {
+		t.Errorf("Expected a mismatched wimdo to be left as is")
+	}
+
+	// a longer name can't grow the copy when something follows it
+	msrd = readTestMSRD(t, msrdTestFilePath)
+	msrd.TextureNames[0] = "PC079404_WAIST_RENAMED_TO_SOMETHING_MUCH_LONGER"
+	if err := msrd.UpdateMetaData(); err != nil {
+		t.Fatal(err)
+	}
+	followed := append(append(formats.MXMD{}, origMXMD...), bytes.Repeat([]byte{0xFF}, 0x10)...)
+	err = followed.SetUncachedTextures(origMetaData, msrd.MetaData)
+	if err == nil || !strings.Contains(err.Error(), "too small") {
+		t.Errorf("Expected the wimdo region to be too small, got %v", err)
+	}
+
+	truncated := origMXMD[:start+0x20]
+	if err := truncated.CheckUncachedTextures(origMetaData); err == nil || !strings.Contains(err.Error(), "smaller than") {
+		t.Errorf("Expected a truncated wimdo to be rejected, got %v", err)
+	}
+}
+
+func TestMSRDValidate(t *testing.T) {
+	msrd := readTestMSRD(t, "formats_testdata/wismt/pc079404.wismt")
+	if problems := msrd.Validate(); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+
+	msrd.DataItems[1].Size = 0x100000
+	msrd.DataItems[3].Offset += 0x10
+	msrd.FileItems[2].UncompressedSize++
+	problems := msrd.Validate()
+	problemsText := fmt.Sprint(problems)
+	for _, expected := range []string{"Data item 1 (shaderbundle)", "Data item 3 at", "Mips of data item 3",
+		"File 2 is"} {
+		if !strings.Contains(problemsText, expected) {
+			t.Errorf("Expected a problem with %q, got %v", expected, problems)
+		}
+	}
+
+	cachedTextures, err := msrd.GetCachedTextures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mibl := range cachedTextures {
+		if err := mibl.Validate(); err != nil {
+			t.Errorf("Expected the cached texture to be valid: %s", err)
+		}
+	}
+	truncated := cachedTextures[0][binary.Size(formats.MIBLFooter{}):]
+	if err := truncated.Validate(); err == nil {
+		t.Errorf("Expected a truncated MIBL to be rejected")
+	}
+
+	badIndices := readTestMSRD(t, "formats_testdata/wismt/pc079404.wismt")
+	badIndices.MetaHeader.ShaderBundleDataItemIndex = 0
+	badIndices.MetaHeader.TextureCacheDataItemIndex = uint32(len(badIndices.DataItems))
+	problemsText = fmt.Sprint(badIndices.Validate())
+	if !strings.Contains(problemsText, "shaderbundle data item 0 is a model item") ||
+		!strings.Contains(problemsText, "texturecache data item "+fmt.Sprint(len(badIndices.DataItems))) {
+		t.Errorf("Expected the bad header data item indices to be found, got %v", problemsText)
+	}
+
+	noFiles := readTestMSRD(t, "formats_testdata/wismt/pc079404.wismt")
+	noFiles.FileItems = nil
+	noFiles.CompressedFiles = nil
+	problems = noFiles.Validate()
+	if !strings.Contains(fmt.Sprint(problems), "file 0 can't be read") {
+		t.Errorf("Expected the missing files to be found, got %v", problems)
+	}
+}
+
+func TestMSRDMetaDataTrailingData(t *testing.T) {
+	msrd := readTestMSRD(t, "formats_testdata/wismt/pc079404.wismt")
+	if err := msrd.UpdateMetaData(); err != nil {
+		t.Fatalf("Expected padding after the texture names to be fine: %s", err)
+	}
+
+	msrd = readTestMSRD(t, "formats_testdata/wismt/pc079404.wismt")
+	msrd.MetaData[len(msrd.MetaData)-1] = 0xAB
+	if err := msrd.UpdateMetaData(); err == nil || !strings.HasPrefix(err.Error(), "Unknown data") {
+		t.Errorf("Expected data after the texture names to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/3096/furnace/dds"
+	"github.com/3096/furnace/furnace"
+	"github.com/3096/furnace/furnace/formats"
+)
+
+func isPowerOfTwo(n uint32) bool {
+	return n != 0 && n&(n-1) == 0
+}
+
+// GetSwizzled lays out surfaces made of whole GOBs, 64 bytes wide and 8 blocks tall, at least at power of two sizes
+func canSwizzle(width, height uint32, format dds.DXGIFormat) bool {
+	formatInfo := dds.DXGI_FORMAT_INFO_MAP[format]
+	bytesPerBlock := formatInfo.BitsPerPixel * formatInfo.BlockSideLen * formatInfo.BlockSideLen / 8
+	return isPowerOfTwo(width) && isPowerOfTwo(height) && width%formatInfo.BlockSideLen == 0 &&
+		height%formatInfo.BlockSideLen == 0 && width/formatInfo.BlockSideLen*bytesPerBlock%64 == 0 &&
+		height/formatInfo.BlockSideLen%8 == 0
+}
+
+// surfaces GetSwizzled can lay out have to survive a round trip, GetDeswizzled has to return an error for the rest
+func testSwizzleRoundTrip(t *testing.T, name string, data []byte, width, height uint32, format dds.DXGIFormat) {
+	// GetSwizzled panics where GetDeswizzled can't read the surface, so only swizzle the ones it can
+	if _, err := furnace.GetDeswizzled(make([]byte, len(data)), width, height, format); err != nil {
+		if canSwizzle(width, height, format) {
+			t.Errorf("%s: %s", name, err)
+		}
+		return
+	}
+
+	swizzled := furnace.GetSwizzled(data, width, height, format)
+	if len(swizzled) != int(furnace.GetSwizzledSize(width, height, format)) {
+		t.Errorf("%s: expected swizzled size %d, got %d", name, furnace.GetSwizzledSize(width, height, format), len(swizzled))
+	}
+	deswizzled, err := furnace.GetDeswizzled(swizzled, width, height, format)
+	if err != nil {
+		t.Errorf("%s: %s", name, err)
+	} else if !bytes.Equal(deswizzled, data) {
+		t.Errorf("%s: expected deswizzled data to match the original", name)
+	}
+	if _, err := furnace.GetDeswizzled(swizzled[:len(swizzled)-1], width, height, format); err == nil {
+		t.Errorf("%s: expected an error deswizzling short data", name)
+	}
+}
+
+func TestSwizzleRoundTrip(t *testing.T) {
+	sizes := []uint32{1, 4, 8, 16, 24, 32, 48, 64, 128, 256, 512, 1024}
+	random := rand.New(rand.NewSource(3096))
+
+	for format, formatInfo := range dds.DXGI_FORMAT_INFO_MAP {
+		for _, width := range sizes {
+			for _, height := range sizes {
+				data := make([]byte, furnace.GetSwizzledSize(width, height, format))
+				random.Read(data)
+				testSwizzleRoundTrip(t, fmt.Sprintf("%s %dx%d", formatInfo.Name, width, height), data, width, height, format)
+			}
+		}
+	}
+}
+
+func TestDeswizzleSizes(t *testing.T) {
+	tests := []struct {
+		width, height uint32
+		format        dds.DXGIFormat
+		dataSize      int
+		expectError   bool
+	}{
+		// the smallest MIBL mips are half a GOB wide
+		{16, 32, dds.DXGI_FORMAT_BC1_UNORM, 256, false},
+		{16, 16, dds.DXGI_FORMAT_BC1_UNORM, 128, false},
+		{16, 32, dds.DXGI_FORMAT_BC1_UNORM, 255, true},
+		// not whole GOBs, laid out past the end of the surface
+		{16, 4, dds.DXGI_FORMAT_BC1_UNORM, 32, true},
+		{16, 64, dds.DXGI_FORMAT_BC1_UNORM, 512, true},
+		// whole GOBs, but blocks overlap when the GOB count isn't a power of two
+		{256, 24, dds.DXGI_FORMAT_R8G8B8A8_UNORM, 256 * 24 * 4, true},
+		{48, 1024, dds.DXGI_FORMAT_BC2_UNORM, 48 * 1024, true},
+		{64, 64, dds.DXGI_FORMAT_R8G8B8A8_UNORM, 64*64*4 - 1, true},
+		{0, 0, dds.DXGIFormat(0), 0, true},
+	}
+
+	for _, test := range tests {
+		_, err := furnace.GetDeswizzled(make([]byte, test.dataSize), test.width, test.height, test.format)
+		if test.expectError && err == nil {
+			t.Errorf("Expected an error deswizzling %d bytes of %dx%d format %d", test.dataSize, test.width, test.height,
+				test.format)
+		} else if !test.expectError && err != nil {
+			t.Errorf("Expected no error deswizzling %d bytes of %dx%d format %d, got %s", test.dataSize, test.width,
+				test.height, test.format, err)
+		}
+	}
+}
+
+func TestSwizzleTestTexture(t *testing.T) {
+	textureTestFilePath := "formats_testdata/mibl/03.PC060000_KIZU_ALP.dds"
+
+	textureFile, err := os.Open(textureTestFilePath)
+	defer textureFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, headerDXT10, textures, err := dds.LoadDDS(textureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	width, height := header.Width, header.Height
+	for i, mip := range textures[0] {
+		testSwizzleRoundTrip(t, fmt.Sprintf("mip %d of %s", i, textureTestFilePath), mip, width, height, headerDXT10.DxgiFormat)
+		width, height = width/2, height/2
+	}
+}
+
+func TestDeswizzleWismtTextures(t *testing.T) {
+	msrd := readTestMSRD(t, "formats_testdata/wismt/pc079404.wismt")
+	mips, err := msrd.GetSplitMips()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for textureIndex, mipsMIBL := range mips {
+		mipsFooter, err := mipsMIBL.GetFooter()
+		if err != nil {
+			t.Fatal(err)
+		}
+		format := formats.MIBLFormatToDXGIFormat[mipsFooter.Format]
+		width, height := mipsFooter.Width*2, mipsFooter.Height*2
+
+		_, swizzled, err := formats.ExtractXBC1(bytes.NewReader(msrd.CompressedFiles[formats.MSRD_FILE_INDEX_TEXTURE_START+textureIndex]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		deswizzled, err := furnace.GetDeswizzled(swizzled, width, height, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(furnace.GetSwizzled(deswizzled, width, height, format), swizzled) {
+			t.Errorf("Expected texture %d to survive a deswizzle round trip", textureIndex)
+		}
+	}
+}